@@ -0,0 +1,185 @@
+package bloom
+
+import "sync"
+
+// batchChunkSize bounds how many keys a batch call hashes before touching the
+// bit array, and with it the scratch memory the call needs.
+const batchChunkSize = 1 << 16
+
+// maxBatchRegions bounds how many regions the bit array is split into when
+// grouping positions; see regionShift.
+const maxBatchRegions = 1 << 14
+
+// SetParallelism sets how many goroutines AddBatch and ContainsBatch shard
+// their work across. Values below 1 mean 1, i.e. no extra goroutines. Shards
+// only run at the same time on separate CPUs, so values above GOMAXPROCS add
+// scheduling overhead without any speedup.
+func (bf *Filter) SetParallelism(n int) {
+	bf.parallelism = max(n, 1)
+}
+
+// AddBatch adds every key to the filter. It is equivalent to calling Add for
+// each key, but hashes all keys of a chunk first and then sets their bits
+// grouped by position, so the bit array is walked roughly in order instead of
+// at random. With SetParallelism or WithParallelism above 1, hashing is
+// sharded by key and bit setting by disjoint ranges of the bit array.
+func (bf *Filter) AddBatch(keys [][]byte) {
+	k := int(bf.numHashFuncs)
+	var b batch
+	for start := 0; start < len(keys); start += batchChunkSize {
+		chunk := keys[start:min(start+batchChunkSize, len(keys))]
+		b.reset(len(chunk)*k, false)
+		forEachShard(len(chunk), bf.parallelism, func(lo, hi int) {
+			bf.hashBatch(chunk[lo:hi], b.positions[lo*k:hi*k])
+		})
+		b.group(regionShift(bf.size), regionCount(bf.size))
+
+		// Regions cover disjoint words, so shards never write the same word.
+		forEachShard(len(b.starts)-1, bf.parallelism, func(lo, hi int) {
+			for _, pos := range b.sorted[b.starts[lo]:b.starts[hi]] {
+				bf.bits.set(pos)
+			}
+		})
+	}
+	bf.logger.Info("Added batch to Bloom filter", "count", len(keys))
+}
+
+// ContainsBatch sets out[i] to whether keys[i] might be in the filter, with
+// the same grouped access pattern as AddBatch. out must be at least as long
+// as keys. Like Contains, it must not run concurrently with Add or AddBatch.
+func (bf *Filter) ContainsBatch(keys [][]byte, out []bool) {
+	if len(out) < len(keys) {
+		panic("bloom: ContainsBatch output is shorter than keys")
+	}
+	forEachShard(len(keys), bf.parallelism, func(lo, hi int) {
+		var b batch
+		for start := lo; start < hi; start += batchChunkSize {
+			end := min(start+batchChunkSize, hi)
+			bf.containsChunk(keys[start:end], out[start:end], &b)
+		}
+	})
+}
+
+// containsChunk answers ContainsBatch for at most batchChunkSize keys
+func (bf *Filter) containsChunk(keys [][]byte, out []bool, b *batch) {
+	k := int(bf.numHashFuncs)
+	b.reset(len(keys)*k, true)
+	bf.hashBatch(keys, b.positions)
+	for i := range b.owners {
+		b.owners[i] = int32(i / k)
+	}
+	b.group(regionShift(bf.size), regionCount(bf.size))
+
+	for i := range out {
+		out[i] = true
+	}
+	for i, pos := range b.sorted {
+		if !bf.bits.test(pos) {
+			out[b.sortedOwners[i]] = false
+		}
+	}
+}
+
+// hashBatch writes the numHashFuncs bit positions of keys[i] to positions[i*k:(i+1)*k]
+func (bf *Filter) hashBatch(keys [][]byte, positions []uint64) {
+	k := bf.numHashFuncs
+	loc := locatorOf(bf.hasher)
+	for i, key := range keys {
+		h1, h2 := bf.hasher.Sum128(key)
+		for j := uint(0); j < k; j++ {
+			positions[uint(i)*k+j] = locate(loc, h1, h2, j, bf.size)
+		}
+	}
+}
+
+// batch holds the scratch slices of a batch call so they are reused across chunks
+type batch struct {
+	positions    []uint64
+	owners       []int32 // owners[i] is the key that positions[i] belongs to
+	sorted       []uint64
+	sortedOwners []int32
+	starts       []int // region r occupies sorted[starts[r]:starts[r+1]]
+}
+
+// reset sizes the scratch slices for n positions, with owners if withOwners is set
+func (b *batch) reset(n int, withOwners bool) {
+	b.positions = resize(b.positions, n)
+	b.sorted = resize(b.sorted, n)
+	if withOwners {
+		b.owners = resize(b.owners, n)
+		b.sortedOwners = resize(b.sortedOwners, n)
+	}
+}
+
+// group counting-sorts positions, and owners alongside them if present, by
+// region into sorted. A region spans 1<<shift bits, so the positions of one
+// region share a small run of words that stays in cache while it is visited.
+// Ordering within a region is left as is; it does not affect locality.
+func (b *batch) group(shift uint, regions int) {
+	b.starts = resize(b.starts, regions+1)
+	clear(b.starts)
+	for _, pos := range b.positions {
+		b.starts[pos>>shift+1]++
+	}
+	for r := 1; r <= regions; r++ {
+		b.starts[r] += b.starts[r-1]
+	}
+
+	// next[r] is where the next position of region r goes. It aliases starts,
+	// which afterwards holds the end of every region and is shifted back by one.
+	next := b.starts[:regions]
+	withOwners := b.owners != nil
+	for i, pos := range b.positions {
+		r := pos >> shift
+		b.sorted[next[r]] = pos
+		if withOwners {
+			b.sortedOwners[next[r]] = b.owners[i]
+		}
+		next[r]++
+	}
+	copy(b.starts[1:], b.starts[:regions])
+	b.starts[0] = 0
+}
+
+// regionShift returns the smallest shift, of at least one word, that splits
+// a bit array of size bits into at most maxBatchRegions regions
+func regionShift(size uint) uint {
+	shift := uint(6)
+	for uint64(max(size, 1)-1)>>shift >= maxBatchRegions {
+		shift++
+	}
+	return shift
+}
+
+// regionCount returns the number of regions regionShift splits size bits into
+func regionCount(size uint) int {
+	return int(uint64(max(size, 1)-1)>>regionShift(size)) + 1
+}
+
+// resize returns s with length n, reallocating only if its capacity is too small
+func resize[T any](s []T, n int) []T {
+	if cap(s) < n {
+		return make([]T, n)
+	}
+	return s[:n]
+}
+
+// forEachShard splits [0, n) into at most p contiguous shards and calls fn on
+// each, concurrently if there is more than one
+func forEachShard(n, p int, fn func(lo, hi int)) {
+	p = min(p, n)
+	if p <= 1 {
+		fn(0, n)
+		return
+	}
+	var wg sync.WaitGroup
+	for s := 0; s < p; s++ {
+		lo, hi := s*n/p, (s+1)*n/p
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(lo, hi)
+		}()
+	}
+	wg.Wait()
+}
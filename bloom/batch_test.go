@@ -0,0 +1,79 @@
+package bloom
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestAddBatchMatchesAdd(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	tests := []struct {
+		name        string
+		size        uint
+		numHash     uint
+		numKeys     int
+		parallelism int
+	}{
+		{"Small filter", 100, 3, 50, 1},
+		{"Size not a multiple of 64", 1000, 5, 300, 1},
+		{"Many regions", 10_000_000, 7, 20_000, 1},
+		{"More keys than one chunk", 1_000_000, 4, batchChunkSize + 1234, 1},
+		{"Parallel", 10_000_000, 7, 20_000, 4},
+		{"Parallel with more shards than regions", 128, 3, 100, 8},
+		{"Parallel with more shards than keys", 10_000, 3, 3, 8},
+		{"Empty batch", 1000, 3, 0, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := make([][]byte, tt.numKeys)
+			for i := range keys {
+				keys[i] = []byte(fmt.Sprintf("key-%d", i))
+			}
+
+			expected := NewBloomFilter(tt.size, tt.numHash, logger)
+			for _, key := range keys {
+				expected.Add(key)
+			}
+			batched := NewBloomFilter(tt.size, tt.numHash, logger)
+			batched.SetParallelism(tt.parallelism)
+			batched.AddBatch(keys)
+			if !slices.Equal(batched.bits, expected.bits) {
+				t.Fatalf("AddBatch() set different bits than Add")
+			}
+
+			queries := append(keys, []byte("absent-1"), []byte("absent-2"))
+			out := make([]bool, len(queries))
+			batched.ContainsBatch(queries, out)
+			for i, key := range queries {
+				if out[i] != expected.Contains(key) {
+					t.Errorf("ContainsBatch()[%d] = %v, Contains(%q) = %v", i, out[i], key, !out[i])
+				}
+			}
+		})
+	}
+}
+
+func TestContainsBatchShortOutput(t *testing.T) {
+	bf := NewBloomFilter(100, 3, nil)
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected ContainsBatch to panic when out is shorter than keys")
+		}
+	}()
+	bf.ContainsBatch([][]byte{[]byte("a"), []byte("b")}, make([]bool, 1))
+}
+
+func TestNewWithParallelism(t *testing.T) {
+	bf, err := New(WithCapacity(1000), WithFPR(0.01), WithParallelism(4))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if bf.parallelism != 4 {
+		t.Errorf("parallelism = %d, want 4", bf.parallelism)
+	}
+}
@@ -0,0 +1,42 @@
+package bloom
+
+import "math/bits"
+
+// bitset is a fixed-size array of bits packed into 64-bit words. Bit i lives in
+// word i/64 at position i%64, so a filter of m bits needs only ceil(m/64) words.
+type bitset []uint64
+
+// newBitset allocates a zeroed bitset large enough to hold size bits
+func newBitset(size uint) bitset {
+	return make(bitset, (size+63)/64)
+}
+
+// set sets bit i
+func (b bitset) set(i uint64) {
+	b[i>>6] |= 1 << (i & 63)
+}
+
+// test reports whether bit i is set
+func (b bitset) test(i uint64) bool {
+	return b[i>>6]&(1<<(i&63)) != 0
+}
+
+// count returns the number of set bits using a hardware popcount per word
+func (b bitset) count() uint {
+	n := 0
+	for _, w := range b {
+		n += bits.OnesCount64(w)
+	}
+	return uint(n)
+}
+
+// packBools converts the legacy one-bool-per-bit representation into a bitset
+func packBools(bools []bool) bitset {
+	b := newBitset(uint(len(bools)))
+	for i, bit := range bools {
+		if bit {
+			b.set(uint64(i))
+		}
+	}
+	return b
+}
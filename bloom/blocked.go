@@ -0,0 +1,259 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/bits"
+)
+
+const (
+	// CacheLineBlock is the block size of a BlockedFilter that confines each
+	// element to one 64-byte cache line
+	CacheLineBlock = 512
+	// RegisterBlock is the block size of a register-blocked BlockedFilter that
+	// confines each element to one 64-bit word, so Add and Contains are a single
+	// OR or AND with a mask. It is the fastest mode but needs more bits per
+	// element than CacheLineBlock for the same false positive rate.
+	RegisterBlock = 64
+)
+
+// BlockedFilter is a blocked Bloom filter (Putze, Sanders and Singler, 2007).
+// The first hash selects a block of blockBits bits and all k bits of an
+// element are set inside that block, so a lookup touches one cache line, or
+// one word in register-blocked mode, instead of up to k random ones.
+//
+// The price is a higher false positive rate than a Filter of the same size:
+// blocks receive uneven numbers of elements and the fuller ones answer true
+// more often. Use OptimalBlockedParameters rather than OptimalSize to size it.
+type BlockedFilter struct {
+	words        bitset
+	numBlocks    uint
+	blockBits    uint
+	numHashFuncs uint
+	hasher       Hasher
+	logger       *slog.Logger
+}
+
+// NewBlockedFilter creates a new blocked Bloom filter of at least size bits,
+// rounded up to whole blocks of blockBits, which must be CacheLineBlock or
+// RegisterBlock. It hashes with xxHash64, as FNV-1a would dominate the cost of
+// a lookup. A nil logger discards log output.
+func NewBlockedFilter(size uint, numHashFuncs uint, blockBits uint, logger *slog.Logger) (*BlockedFilter, error) {
+	return NewBlockedFilterWithHasher(size, numHashFuncs, blockBits, NewXXHash64Hasher(0), logger)
+}
+
+// NewBlockedFilterWithHasher creates a new blocked Bloom filter that derives bit positions with the given hasher
+func NewBlockedFilterWithHasher(size uint, numHashFuncs uint, blockBits uint, hasher Hasher, logger *slog.Logger) (*BlockedFilter, error) {
+	if err := validateBlocked(size, numHashFuncs, blockBits); err != nil {
+		return nil, err
+	}
+	if hasher == nil {
+		hasher = NewXXHash64Hasher(0)
+	}
+	logger = orDiscard(logger)
+	numBlocks := (size + blockBits - 1) / blockBits
+	bf := &BlockedFilter{
+		words:        newBitset(numBlocks * blockBits),
+		numBlocks:    numBlocks,
+		blockBits:    blockBits,
+		numHashFuncs: numHashFuncs,
+		hasher:       hasher,
+		logger:       logger,
+	}
+
+	bf.logger.Info("Created new blocked Bloom filter", "size", numBlocks*blockBits, "blockBits", blockBits,
+		"numHashFuncs", numHashFuncs, "hasher", hasher.Scheme())
+	return bf, nil
+}
+
+// validateBlocked checks the parameters of a BlockedFilter
+func validateBlocked(size, numHashFuncs, blockBits uint) error {
+	if blockBits != CacheLineBlock && blockBits != RegisterBlock {
+		return fmt.Errorf("%w: block size must be %d or %d bits, got %d", ErrInvalidParameter, CacheLineBlock, RegisterBlock, blockBits)
+	}
+	if size == 0 {
+		return fmt.Errorf("%w: size must be positive", ErrInvalidParameter)
+	}
+	if numHashFuncs == 0 || numHashFuncs > blockBits {
+		return fmt.Errorf("%w: number of hash functions must be in [1, %d], got %d", ErrInvalidParameter, blockBits, numHashFuncs)
+	}
+	return nil
+}
+
+// Add adds an element to the Bloom filter
+func (bf *BlockedFilter) Add(element []byte) {
+	h1, h2 := bf.hasher.Sum128(element)
+	block := bf.block(h1)
+	if bf.blockBits == RegisterBlock {
+		var mask [1]uint64
+		blockMask(h2, bf.numHashFuncs, mask[:])
+		bf.words[block] |= mask[0]
+	} else {
+		var mask [CacheLineBlock / 64]uint64
+		blockMask(h2, bf.numHashFuncs, mask[:])
+		for i, w := range bf.words[block*uint(len(mask)):][:len(mask)] {
+			bf.words[block*uint(len(mask))+uint(i)] = w | mask[i]
+		}
+	}
+	bf.logger.Info("Added element to blocked Bloom filter", "element", string(element), "block", block)
+}
+
+// Contains checks if an element might be in the Bloom filter. It does not log,
+// so that a lookup costs one hash and one block access.
+func (bf *BlockedFilter) Contains(element []byte) bool {
+	h1, h2 := bf.hasher.Sum128(element)
+	block := bf.block(h1)
+	if bf.blockBits == RegisterBlock {
+		var mask [1]uint64
+		blockMask(h2, bf.numHashFuncs, mask[:])
+		return bf.words[block]&mask[0] == mask[0]
+	}
+	var mask [CacheLineBlock / 64]uint64
+	blockMask(h2, bf.numHashFuncs, mask[:])
+	for i, w := range bf.words[block*uint(len(mask)):][:len(mask)] {
+		if w&mask[i] != mask[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// FalsePositiveRate calculates the current false positive rate of the Bloom
+// filter. A query lands in a uniformly random block and tests k distinct bits
+// of it, so the rate is the mean over blocks of the chance that k distinct
+// bits are all set.
+func (bf *BlockedFilter) FalsePositiveRate() float64 {
+	blockWords := bf.blockBits / 64
+	sum := 0.0
+	for b := uint(0); b < bf.numBlocks; b++ {
+		setBits := bf.words[b*blockWords : (b+1)*blockWords].count()
+		sum += blockHitRate(float64(setBits), float64(bf.blockBits), float64(bf.numHashFuncs))
+	}
+	return sum / float64(bf.numBlocks)
+}
+
+// Size returns the number of bits, a whole number of blocks
+func (bf *BlockedFilter) Size() uint {
+	return bf.numBlocks * bf.blockBits
+}
+
+// Save serializes the Bloom filter to a writer in the framed binary format.
+// The body is the size, block size and hash function count as uint64s followed
+// by the words of all blocks in order. The key of a keyed filter is not
+// written; see SaveWithKey.
+func (bf *BlockedFilter) Save(w io.Writer) error {
+	return bf.save(w, false)
+}
+
+// SaveWithKey serializes the Bloom filter like Save but also writes the secret
+// key of a keyed filter, so Load can restore it without LoadWithKey
+func (bf *BlockedFilter) SaveWithKey(w io.Writer) error {
+	return bf.save(w, true)
+}
+
+// save writes the framed format, including a secret hasher key only if includeKey is set
+func (bf *BlockedFilter) save(w io.Writer, includeKey bool) error {
+	h := frameHeader{
+		kind:    kindBlocked,
+		bodyLen: 24 + 8*uint64(len(bf.words)),
+	}
+	h.scheme, h.flags, h.seed = encodeHasher(bf.hasher, includeKey)
+	return writeFrame(w, h, func(w io.Writer) error {
+		var params [24]byte
+		binary.LittleEndian.PutUint64(params[0:8], uint64(bf.Size()))
+		binary.LittleEndian.PutUint64(params[8:16], uint64(bf.blockBits))
+		binary.LittleEndian.PutUint64(params[16:24], uint64(bf.numHashFuncs))
+		if _, err := w.Write(params[:]); err != nil {
+			return err
+		}
+		return writeWords(w, bf.words)
+	})
+}
+
+// Load deserializes the Bloom filter from a reader. A keyed filter saved
+// without its key returns ErrKeyRequired; use LoadWithKey.
+func (bf *BlockedFilter) Load(r io.Reader, logger *slog.Logger) error {
+	return bf.load(r, nil, logger)
+}
+
+// LoadWithKey deserializes a keyed Bloom filter that was saved without its key
+func (bf *BlockedFilter) LoadWithKey(r io.Reader, key [16]byte, logger *slog.Logger) error {
+	return bf.load(r, &key, logger)
+}
+
+// load reads the framed format, supplying key to a keyed filter saved without one
+func (bf *BlockedFilter) load(r io.Reader, key *[16]byte, logger *slog.Logger) error {
+	var size, blockBits, numHashFuncs uint64
+	var words bitset
+	var hasher Hasher
+	err := readFrame(r, kindBlocked, func(h frameHeader, r io.Reader) error {
+		var err error
+		if hasher, err = decodeHasher(h.scheme, h.flags, h.seed, key); err != nil {
+			return err
+		}
+		var params [24]byte
+		if _, err := io.ReadFull(r, params[:]); err != nil {
+			return err
+		}
+		size = binary.LittleEndian.Uint64(params[0:8])
+		blockBits = binary.LittleEndian.Uint64(params[8:16])
+		numHashFuncs = binary.LittleEndian.Uint64(params[16:24])
+		if err := validateBlocked(uint(size), uint(numHashFuncs), uint(blockBits)); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidFormat, err)
+		}
+		if size%blockBits != 0 || size/64 > math.MaxInt/8 {
+			return fmt.Errorf("%w: size %d is not a whole number of %d-bit blocks", ErrInvalidFormat, size, blockBits)
+		}
+		if h.bodyLen != 24+size/8 {
+			return fmt.Errorf("%w: body of %d bytes does not fit %d bits", ErrInvalidFormat, h.bodyLen, size)
+		}
+		words, err = readBitset(r, size/64)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	bf.words = words
+	bf.numBlocks = uint(size / blockBits)
+	bf.blockBits = uint(blockBits)
+	bf.numHashFuncs = uint(numHashFuncs)
+	bf.hasher = hasher
+	bf.logger = orDiscard(logger)
+	return nil
+}
+
+// block maps h1 to a block index with a multiply-shift, which is uniform
+// without the division a modulo would cost
+func (bf *BlockedFilter) block(h1 uint64) uint {
+	hi, _ := bits.Mul64(h1, uint64(bf.numBlocks))
+	return uint(hi)
+}
+
+// blockMask sets numHashFuncs distinct bits in mask, a block of len(mask)
+// words. Each position takes log2 of the block size bits of h2, which is
+// remixed whenever its bits run out, so no two positions depend on the same
+// hash bits. Deriving them by double hashing instead would leave only a few
+// thousand distinct patterns per block and a false positive rate floor.
+func blockMask(h2 uint64, numHashFuncs uint, mask []uint64) {
+	blockBits := uint64(len(mask)) * 64
+	width := uint(bits.TrailingZeros64(blockBits))
+	seed, available := h2, uint(64)
+	for round, set := uint64(1), uint(0); set < numHashFuncs; {
+		if available < width {
+			h2 = fmix64(seed + round*0x9e3779b97f4a7c15)
+			round++
+			available = 64
+		}
+		pos := h2 & (blockBits - 1)
+		h2 >>= width
+		available -= width
+		if bit := uint64(1) << (pos & 63); mask[pos>>6]&bit == 0 {
+			mask[pos>>6] |= bit
+			set++
+		}
+	}
+}
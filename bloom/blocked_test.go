@@ -0,0 +1,236 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestNewBlockedFilterInvalidParameters(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      uint
+		numHash   uint
+		blockBits uint
+	}{
+		{"Zero size", 0, 3, CacheLineBlock},
+		{"Zero hashes", 1000, 0, CacheLineBlock},
+		{"More hashes than block bits", 1000, 65, RegisterBlock},
+		{"Unsupported block size", 1000, 3, 256},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewBlockedFilter(tt.size, tt.numHash, tt.blockBits, nil); !errors.Is(err, ErrInvalidParameter) {
+				t.Errorf("NewBlockedFilter() error = %v, expectedError %v", err, ErrInvalidParameter)
+			}
+		})
+	}
+}
+
+func TestBlockedFilterFalsePositiveRate(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	tests := []struct {
+		name              string
+		blockBits         uint
+		expectedElements  int
+		falsePositiveRate float64
+		probes            int
+	}{
+		{"Cache line at one percent", CacheLineBlock, 20000, 0.01, 200000},
+		{"Cache line at one in a thousand", CacheLineBlock, 20000, 0.001, 500000},
+		{"Register at one percent", RegisterBlock, 20000, 0.01, 200000},
+		{"Register at one in a thousand", RegisterBlock, 20000, 0.001, 500000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, numHash, err := OptimalBlockedParameters(tt.expectedElements, tt.falsePositiveRate, tt.blockBits)
+			if err != nil {
+				t.Fatalf("OptimalBlockedParameters() error = %v", err)
+			}
+			if size < OptimalSize(tt.expectedElements, tt.falsePositiveRate) || size%tt.blockBits != 0 {
+				t.Errorf("OptimalBlockedParameters() size %d is below OptimalSize or not whole blocks", size)
+			}
+			bf, err := NewBlockedFilter(size, numHash, tt.blockBits, logger)
+			if err != nil {
+				t.Fatalf("NewBlockedFilter() error = %v", err)
+			}
+
+			for i := 0; i < tt.expectedElements; i++ {
+				bf.Add([]byte(fmt.Sprintf("member-%d", i)))
+			}
+			for i := 0; i < tt.expectedElements; i++ {
+				if !bf.Contains([]byte(fmt.Sprintf("member-%d", i))) {
+					t.Fatalf("Expected blocked filter to contain member-%d", i)
+				}
+			}
+
+			falsePositives := 0
+			for i := 0; i < tt.probes; i++ {
+				if bf.Contains([]byte(fmt.Sprintf("probe-%d", i))) {
+					falsePositives++
+				}
+			}
+
+			// The planner's model should predict the measured rate as well as the
+			// classic formula predicts the rate of a Filter.
+			actualFPR := float64(falsePositives) / float64(tt.probes)
+			tolerance := 5 * math.Sqrt(tt.falsePositiveRate*(1-tt.falsePositiveRate)/float64(tt.probes))
+			t.Logf("Target FPR: %f, Actual FPR: %f, Estimated FPR: %f", tt.falsePositiveRate, actualFPR, bf.FalsePositiveRate())
+			if math.Abs(actualFPR-tt.falsePositiveRate) > tolerance {
+				t.Errorf("Actual false positive rate (%f) differs from target (%f) by more than tolerance (%f)", actualFPR, tt.falsePositiveRate, tolerance)
+			}
+			if math.Abs(bf.FalsePositiveRate()-actualFPR) > tolerance {
+				t.Errorf("FalsePositiveRate() = %f, measured %f", bf.FalsePositiveRate(), actualFPR)
+			}
+		})
+	}
+}
+
+func TestBlockedFalsePositiveRateModel(t *testing.T) {
+	const n = 100000
+	size := OptimalSize(n, 0.01)
+	numHash := OptimalHashFunctions(size, n)
+	classic := math.Pow(1-math.Exp(-float64(numHash)*n/float64(size)), float64(numHash))
+
+	cacheLine := BlockedFalsePositiveRate(size, numHash, CacheLineBlock, n)
+	register := BlockedFalsePositiveRate(size, numHash, RegisterBlock, n)
+	if !(classic < cacheLine && cacheLine < register) {
+		t.Errorf("Expected classic %g < cache line %g < register %g at the same size", classic, cacheLine, register)
+	}
+	if rate := BlockedFalsePositiveRate(size, numHash, CacheLineBlock, 0); rate != 0 {
+		t.Errorf("BlockedFalsePositiveRate() = %g for an empty filter, want 0", rate)
+	}
+}
+
+func TestOptimalBlockedParametersInvalid(t *testing.T) {
+	tests := []struct {
+		name              string
+		expectedElements  int
+		falsePositiveRate float64
+		blockBits         uint
+	}{
+		{"Zero elements", 0, 0.01, CacheLineBlock},
+		{"Zero FPR", 1000, 0, CacheLineBlock},
+		{"FPR of one", 1000, 1, CacheLineBlock},
+		{"Unsupported block size", 1000, 0.01, 128},
+		{"Unreachable with register blocks", 1000, 1e-15, RegisterBlock},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := OptimalBlockedParameters(tt.expectedElements, tt.falsePositiveRate, tt.blockBits); !errors.Is(err, ErrInvalidParameter) {
+				t.Errorf("OptimalBlockedParameters() error = %v, expectedError %v", err, ErrInvalidParameter)
+			}
+		})
+	}
+}
+
+func TestBlockedFilterSaveLoad(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	for _, blockBits := range []uint{CacheLineBlock, RegisterBlock} {
+		t.Run(fmt.Sprintf("%d-bit blocks", blockBits), func(t *testing.T) {
+			bf, err := NewBlockedFilterWithHasher(5000, 6, blockBits, NewMurmur3Hasher(3), logger)
+			if err != nil {
+				t.Fatalf("NewBlockedFilterWithHasher() error = %v", err)
+			}
+			for i := 0; i < 300; i++ {
+				bf.Add([]byte(fmt.Sprintf("element-%d", i)))
+			}
+
+			var buf bytes.Buffer
+			if err := bf.Save(&buf); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+			loaded := &BlockedFilter{}
+			if err := loaded.Load(bytes.NewReader(buf.Bytes()), logger); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if loaded.Size() != bf.Size() || loaded.blockBits != blockBits || loaded.numHashFuncs != 6 || loaded.hasher != bf.hasher {
+				t.Errorf("Loaded parameters differ from saved")
+			}
+			for i := 0; i < 300; i++ {
+				if !loaded.Contains([]byte(fmt.Sprintf("element-%d", i))) {
+					t.Errorf("Expected loaded filter to contain element-%d", i)
+				}
+			}
+
+			// A block size the filter does not support is rejected even with a valid checksum.
+			data := bytes.Clone(buf.Bytes())
+			binary.LittleEndian.PutUint64(data[frameHeaderSize+8:], 128)
+			binary.LittleEndian.PutUint32(data[len(data)-4:], crc32.Checksum(data[:len(data)-4], castagnoli))
+			if err := (&BlockedFilter{}).Load(bytes.NewReader(data), logger); !errors.Is(err, ErrInvalidFormat) {
+				t.Errorf("Load() error = %v, expectedError %v", err, ErrInvalidFormat)
+			}
+
+			// A header for 2^56 bits followed by none of them ends in a short
+			// read rather than an allocation of the size it claims.
+			const hugeSize = 1 << 56
+			var params [24]byte
+			binary.LittleEndian.PutUint64(params[0:8], hugeSize)
+			binary.LittleEndian.PutUint64(params[8:16], uint64(blockBits))
+			binary.LittleEndian.PutUint64(params[16:24], 6)
+			huge := truncatedFrame(kindBlocked, 24+hugeSize/8, params[:])
+			if err := (&BlockedFilter{}).Load(bytes.NewReader(huge), logger); !errors.Is(err, ErrTruncated) {
+				t.Errorf("Load() error = %v, expectedError %v", err, ErrTruncated)
+			}
+		})
+	}
+}
+
+func TestBlockedFilterSaveLoadWithKey(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	key := [16]byte{0: 0xaa, 15: 0x55}
+	bf, err := NewBlockedFilterWithHasher(5000, 6, CacheLineBlock, NewKeyedHasher(key), logger)
+	if err != nil {
+		t.Fatalf("NewBlockedFilterWithHasher() error = %v", err)
+	}
+	for i := 0; i < 300; i++ {
+		bf.Add([]byte(fmt.Sprintf("element-%d", i)))
+	}
+
+	tests := []struct {
+		name         string
+		save         func(w *bytes.Buffer) error
+		expectedLoad error
+	}{
+		{"Save", func(w *bytes.Buffer) error { return bf.Save(w) }, ErrKeyRequired},
+		{"SaveWithKey", func(w *bytes.Buffer) error { return bf.SaveWithKey(w) }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.save(&buf); err != nil {
+				t.Fatalf("%s() error = %v", tt.name, err)
+			}
+			if keyWritten := bytes.Contains(buf.Bytes(), key[:]); keyWritten != (tt.expectedLoad == nil) {
+				t.Errorf("%s() wrote the key: %v", tt.name, keyWritten)
+			}
+			if err := (&BlockedFilter{}).Load(bytes.NewReader(buf.Bytes()), logger); !errors.Is(err, tt.expectedLoad) {
+				t.Errorf("Load() error = %v, expectedError %v", err, tt.expectedLoad)
+			}
+
+			loaded := &BlockedFilter{}
+			if err := loaded.LoadWithKey(bytes.NewReader(buf.Bytes()), key, logger); err != nil {
+				t.Fatalf("LoadWithKey() error = %v", err)
+			}
+			for i := 0; i < 300; i++ {
+				if !loaded.Contains([]byte(fmt.Sprintf("element-%d", i))) {
+					t.Fatalf("Expected loaded filter to contain element-%d", i)
+				}
+			}
+			if loaded.FalsePositiveRate() != bf.FalsePositiveRate() {
+				t.Errorf("FalsePositiveRate() = %v after load, want %v", loaded.FalsePositiveRate(), bf.FalsePositiveRate())
+			}
+		})
+	}
+}
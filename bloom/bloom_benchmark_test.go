@@ -1,9 +1,11 @@
 package bloom
 
 import (
-	"testing"
+	"fmt"
 	"log/slog"
 	"os"
+	"runtime"
+	"testing"
 )
 
 func BenchmarkBloomFilterAdd(b *testing.B) {
@@ -38,4 +40,128 @@ func BenchmarkOptimalHashFunctions(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		OptimalHashFunctions(1000, 100)
 	}
-}
\ No newline at end of file
+}
+
+// benchmarkBatchKeys returns n distinct keys for the batch benchmarks
+func benchmarkBatchKeys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("benchmark-key-%d", i))
+	}
+	return keys
+}
+
+// The batch benchmarks use a filter sized for ten million elements at 1%, about
+// 11 MiB, so that bit accesses miss the cache as they do in production. The
+// loops they are compared with set and test bits through addHash and
+// containsHash, the body of Add and Contains without their per-element log
+// calls, so that only the order of the bit accesses differs.
+func BenchmarkBloomFilterAddLoop(b *testing.B) {
+	bf := NewBloomFilter(OptimalSize(10_000_000, 0.01), 7, nil)
+	keys := benchmarkBatchKeys(100_000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, key := range keys {
+			bf.addHash(bf.hasher.Sum128(key))
+		}
+	}
+	b.ReportMetric(float64(b.N*len(keys))/b.Elapsed().Seconds(), "keys/s")
+}
+
+// batchParallelism returns the parallelism levels worth benchmarking: sharding
+// across more goroutines than GOMAXPROCS only adds scheduling overhead, so on a
+// single CPU there is nothing to compare against.
+func batchParallelism() []int {
+	if procs := runtime.GOMAXPROCS(0); procs > 1 {
+		return []int{1, procs}
+	}
+	return []int{1}
+}
+
+func BenchmarkBloomFilterAddBatch(b *testing.B) {
+	for _, parallelism := range batchParallelism() {
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			bf := NewBloomFilter(OptimalSize(10_000_000, 0.01), 7, nil)
+			bf.SetParallelism(parallelism)
+			keys := benchmarkBatchKeys(100_000)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				bf.AddBatch(keys)
+			}
+			b.ReportMetric(float64(b.N*len(keys))/b.Elapsed().Seconds(), "keys/s")
+		})
+	}
+}
+
+func BenchmarkBloomFilterContainsLoop(b *testing.B) {
+	bf := NewBloomFilter(OptimalSize(10_000_000, 0.01), 7, nil)
+	keys := benchmarkBatchKeys(100_000)
+	bf.AddBatch(keys)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, key := range keys {
+			bf.containsHash(bf.hasher.Sum128(key))
+		}
+	}
+	b.ReportMetric(float64(b.N*len(keys))/b.Elapsed().Seconds(), "keys/s")
+}
+
+func BenchmarkBloomFilterContainsBatch(b *testing.B) {
+	for _, parallelism := range batchParallelism() {
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			bf := NewBloomFilter(OptimalSize(10_000_000, 0.01), 7, nil)
+			bf.SetParallelism(parallelism)
+			keys := benchmarkBatchKeys(100_000)
+			bf.AddBatch(keys)
+			out := make([]bool, len(keys))
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				bf.ContainsBatch(keys, out)
+			}
+			b.ReportMetric(float64(b.N*len(keys))/b.Elapsed().Seconds(), "keys/s")
+		})
+	}
+}
+
+// BenchmarkLargeFilterContains compares lookups in filters sized for ten
+// million elements at 1%, too large for the CPU caches. All of them hash with
+// xxHash64 so that only the memory access pattern differs.
+func BenchmarkLargeFilterContains(b *testing.B) {
+	const n = 10_000_000
+	keys := benchmarkBatchKeys(1 << 16)
+
+	b.Run("classic", func(b *testing.B) {
+		size := OptimalSize(n, 0.01)
+		bf := NewBloomFilterWithHasher(size, OptimalHashFunctions(size, n), NewXXHash64Hasher(0), nil)
+		benchmarkContains(b, bf.Add, bf.Contains, keys)
+	})
+	for _, blockBits := range []uint{CacheLineBlock, RegisterBlock} {
+		b.Run(fmt.Sprintf("blocked-%d", blockBits), func(b *testing.B) {
+			size, numHash, err := OptimalBlockedParameters(n, 0.01, blockBits)
+			if err != nil {
+				b.Fatal(err)
+			}
+			bf, err := NewBlockedFilter(size, numHash, blockBits, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			benchmarkContains(b, bf.Add, bf.Contains, keys)
+		})
+	}
+}
+
+// benchmarkContains adds every other key and then queries all of them round-robin
+func benchmarkContains(b *testing.B, add func([]byte), contains func([]byte) bool, keys [][]byte) {
+	b.Helper()
+	for i := 0; i < len(keys); i += 2 {
+		add(keys[i])
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		contains(keys[i&(len(keys)-1)])
+	}
+}
@@ -3,6 +3,9 @@ package bloom
 
 import (
 	"bytes"
+	"encoding/gob"
+	"fmt"
+	"hash/fnv"
 	"log/slog"
 	"math"
 	"math/rand"
@@ -58,8 +61,8 @@ func TestBloomFilter(t *testing.T) {
 			size:            10,
 			numHashFuncs:    2,
 			addElements:     []string{"red", "green", "blue"},
-			checkElements:   []string{"red", "green", "blue", "yellow", "purple"},
-			expectedResults: []bool{true, true, true, true, false}, // Note: May have false positives
+			checkElements:   []string{"red", "green", "blue"},
+			expectedResults: []bool{true, true, true}, // Absent elements may be false positives, so only added ones are checked
 		},
 	}
 
@@ -135,6 +138,88 @@ func TestOptimalHashFunctions(t *testing.T) {
 	}
 }
 
+func TestDistinctBitPositions(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	// With a large bit array, k hash functions should almost always land on k
+	// different bits for a single element.
+	bf := NewBloomFilter(1<<20, 7, logger)
+	bf.Add([]byte("hello"))
+
+	if setBits := bf.bits.count(); setBits != 7 {
+		t.Errorf("Expected a single element to set 7 bits, but it set %d", setBits)
+	}
+}
+
+func TestLocationDegenerateStep(t *testing.T) {
+	tests := []struct {
+		name string
+		h2   uint64
+		size uint
+	}{
+		{"Zero step", 0, 1000},
+		{"Step a multiple of an even size", 3 * 1024, 1024},
+		{"Step a multiple of an odd size", 7 * 1001, 1001},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// With plain double hashing every position would be h1 mod size.
+			positions := make(map[uint64]bool)
+			for i := uint(0); i < 7; i++ {
+				positions[location(12345, tt.h2, i, tt.size)] = true
+			}
+			if len(positions) < 6 {
+				t.Errorf("Expected 7 hash functions to reach at least 6 positions, got %d", len(positions))
+			}
+		})
+	}
+}
+
+func TestMeasuredFalsePositiveRate(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	tests := []struct {
+		name              string
+		expectedElements  int
+		falsePositiveRate float64
+		probes            int
+	}{
+		{"One percent", 10000, 0.01, 200000},
+		{"Five percent", 5000, 0.05, 100000},
+		{"One in a thousand", 20000, 0.001, 500000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size := OptimalSize(tt.expectedElements, tt.falsePositiveRate)
+			numHashFuncs := OptimalHashFunctions(size, tt.expectedElements)
+			bf := NewBloomFilter(size, numHashFuncs, logger)
+
+			for i := 0; i < tt.expectedElements; i++ {
+				bf.Add([]byte(fmt.Sprintf("member-%d", i)))
+			}
+
+			falsePositives := 0
+			for i := 0; i < tt.probes; i++ {
+				if bf.Contains([]byte(fmt.Sprintf("probe-%d", i))) {
+					falsePositives++
+				}
+			}
+
+			// The measured rate is binomially distributed around the target, so
+			// allow five standard deviations on either side of it.
+			actualFPR := float64(falsePositives) / float64(tt.probes)
+			tolerance := 5 * math.Sqrt(tt.falsePositiveRate*(1-tt.falsePositiveRate)/float64(tt.probes))
+			t.Logf("Target FPR: %f, Actual FPR: %f, Estimated FPR: %f", tt.falsePositiveRate, actualFPR, bf.FalsePositiveRate())
+
+			if math.Abs(actualFPR-tt.falsePositiveRate) > tolerance {
+				t.Errorf("Actual false positive rate (%f) differs from target (%f) by more than tolerance (%f)", actualFPR, tt.falsePositiveRate, tolerance)
+			}
+		})
+	}
+}
+
 // func TestFalsePositiveRate(t *testing.T) {
 // 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
 
@@ -302,8 +387,8 @@ func TestSaveAndLoad(t *testing.T) {
 			if loadedBF.size != originalBF.size {
 				t.Errorf("Loaded filter size (%d) doesn't match original (%d)", loadedBF.size, originalBF.size)
 			}
-			if len(loadedBF.hashFuncs) != len(originalBF.hashFuncs) {
-				t.Errorf("Loaded filter hash functions count (%d) doesn't match original (%d)", len(loadedBF.hashFuncs), len(originalBF.hashFuncs))
+			if loadedBF.numHashFuncs != originalBF.numHashFuncs {
+				t.Errorf("Loaded filter hash functions count (%d) doesn't match original (%d)", loadedBF.numHashFuncs, originalBF.numHashFuncs)
 			}
 
 			// Check elements
@@ -324,3 +409,68 @@ func TestSaveAndLoad(t *testing.T) {
 		})
 	}
 }
+
+func TestSavePackedSize(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	size := OptimalSize(10000, 0.01)
+	bf := NewBloomFilter(size, OptimalHashFunctions(size, 10000), logger)
+	for i := 0; i < 10000; i++ {
+		bf.Add([]byte(fmt.Sprintf("member-%d", i)))
+	}
+
+	var buf bytes.Buffer
+	if err := bf.Save(&buf); err != nil {
+		t.Fatalf("Failed to save Bloom filter: %v", err)
+	}
+
+	// gob writes each word in at most 9 bytes, so a packed filter should stay
+	// well below one byte per bit.
+	if limit := int(size) * 9 / 64 * 12 / 10; buf.Len() > limit {
+		t.Errorf("Saved filter is %d bytes for %d bits, expected at most %d", buf.Len(), size, limit)
+	}
+}
+
+func TestLoadLegacyBoolEncoding(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	// Build the filter the way the first release did: every hash function was
+	// a fresh 64-bit FNV-1, so each element set the single bit at its digest
+	// modulo the size, and Save wrote the bits as a gob of bools.
+	const size, numHash = 1000, 3
+	elements := []string{"apple", "banana", "cherry"}
+	bitArray := make([]bool, size)
+	for _, elem := range elements {
+		h := fnv.New64()
+		h.Write([]byte(elem))
+		bitArray[h.Sum64()%size] = true
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(struct {
+		BitArray []bool
+		Size     uint
+		NumHash  uint
+	}{
+		BitArray: bitArray,
+		Size:     size,
+		NumHash:  numHash,
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode legacy Bloom filter: %v", err)
+	}
+
+	loadedBF := &Filter{}
+	if err := loadedBF.Load(&buf, logger); err != nil {
+		t.Fatalf("Failed to load legacy Bloom filter: %v", err)
+	}
+	for _, elem := range elements {
+		if !loadedBF.Contains([]byte(elem)) {
+			t.Errorf("Expected loaded filter to contain %s", elem)
+		}
+	}
+	for i, set := range bitArray {
+		if loadedBF.bits.test(uint64(i)) != set {
+			t.Errorf("Loaded bit %d = %v, want %v", i, !set, set)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package bloom
+
+import (
+	"io"
+	"log/slog"
+	"math"
+	"sync/atomic"
+)
+
+// ConcurrentFilter is a Bloom filter that is safe for concurrent use by multiple
+// goroutines. Bits are set with an atomic OR on the word that holds them and
+// read with atomic loads, so Add and Contains never take a lock.
+//
+// It uses the same hashing and bit layout as Filter, so a snapshot of a
+// ConcurrentFilter answers queries exactly like a Filter that saw the same
+// elements.
+type ConcurrentFilter struct {
+	words        []uint64
+	size         uint
+	numHashFuncs uint
+	hasher       Hasher
+	logger       *slog.Logger
+}
+
+// NewConcurrentFilter creates a new concurrency-safe Bloom filter with the given size and number of hash functions.
+// A nil logger discards log output.
+func NewConcurrentFilter(size uint, numHashFuncs uint, logger *slog.Logger) *ConcurrentFilter {
+	cf := &ConcurrentFilter{
+		words:        newBitset(size),
+		size:         size,
+		numHashFuncs: numHashFuncs,
+		hasher:       NewFNV1aHasher(),
+		logger:       orDiscard(logger),
+	}
+
+	cf.logger.Info("Created new concurrent Bloom filter", "size", size, "numHashFuncs", numHashFuncs)
+	return cf
+}
+
+// Add adds an element to the Bloom filter. It may be called concurrently with Add and Contains.
+func (cf *ConcurrentFilter) Add(element []byte) {
+	// Hashers keep no state between calls, so every goroutine hashes
+	// independently without sharing a hash.Hash instance.
+	h1, h2 := cf.hasher.Sum128(element)
+	loc := locatorOf(cf.hasher)
+	for i := uint(0); i < cf.numHashFuncs; i++ {
+		index := locate(loc, h1, h2, i, cf.size)
+		atomicOr(&cf.words[index>>6], 1<<(index&63))
+	}
+	cf.logger.Info("Added element to concurrent Bloom filter", "element", string(element))
+}
+
+// Contains checks if an element might be in the Bloom filter. It may be called concurrently with Add and Contains.
+func (cf *ConcurrentFilter) Contains(element []byte) bool {
+	h1, h2 := cf.hasher.Sum128(element)
+	loc := locatorOf(cf.hasher)
+	for i := uint(0); i < cf.numHashFuncs; i++ {
+		index := locate(loc, h1, h2, i, cf.size)
+		if atomic.LoadUint64(&cf.words[index>>6])&(1<<(index&63)) == 0 {
+			cf.logger.Debug("Element not found in concurrent Bloom filter", "element", string(element), "hashFunc", i)
+			return false
+		}
+	}
+	cf.logger.Info("Element possibly in concurrent Bloom filter", "element", string(element))
+	return true
+}
+
+// FalsePositiveRate calculates the current false positive rate of the Bloom filter
+func (cf *ConcurrentFilter) FalsePositiveRate() float64 {
+	probability := float64(cf.snapshotBits().count()) / float64(cf.size)
+	return math.Pow(probability, float64(cf.numHashFuncs))
+}
+
+// Snapshot returns a Filter holding a copy of the current bits. Elements added
+// concurrently with the snapshot may or may not be included in it.
+func (cf *ConcurrentFilter) Snapshot() *Filter {
+	return &Filter{
+		bits:         cf.snapshotBits(),
+		size:         cf.size,
+		numHashFuncs: cf.numHashFuncs,
+		hasher:       cf.hasher,
+		logger:       cf.logger,
+	}
+}
+
+// Save serializes a snapshot of the Bloom filter to a writer in the same format as Filter.Save
+func (cf *ConcurrentFilter) Save(w io.Writer) error {
+	return cf.Snapshot().Save(w)
+}
+
+// Load deserializes the Bloom filter from a reader. It must not be called
+// concurrently with any other method.
+func (cf *ConcurrentFilter) Load(r io.Reader, logger *slog.Logger) error {
+	bf := &Filter{}
+	if err := bf.Load(r, logger); err != nil {
+		return err
+	}
+	cf.words = bf.bits
+	cf.size = bf.size
+	cf.numHashFuncs = bf.numHashFuncs
+	cf.hasher = bf.hasher
+	cf.logger = orDiscard(logger)
+	return nil
+}
+
+// snapshotBits copies the words with atomic loads
+func (cf *ConcurrentFilter) snapshotBits() bitset {
+	bits := make(bitset, len(cf.words))
+	for i := range cf.words {
+		bits[i] = atomic.LoadUint64(&cf.words[i])
+	}
+	return bits
+}
+
+// atomicOr sets mask in *addr with a compare-and-swap loop. The loop exits
+// without writing when the bits are already set, which is the common case for
+// a well-populated filter.
+func atomicOr(addr *uint64, mask uint64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		if old&mask == mask || atomic.CompareAndSwapUint64(addr, old, old|mask) {
+			return
+		}
+	}
+}
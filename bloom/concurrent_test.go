@@ -0,0 +1,83 @@
+package bloom
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentFilterParallelAddAndContains(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	const (
+		goroutines = 16
+		perWorker  = 2000
+	)
+	size := OptimalSize(goroutines*perWorker, 0.01)
+	cf := NewConcurrentFilter(size, OptimalHashFunctions(size, goroutines*perWorker), logger)
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				elem := []byte(fmt.Sprintf("worker-%d-%d", g, i))
+				cf.Add(elem)
+				if !cf.Contains(elem) {
+					t.Errorf("Expected Contains(%s) to be true right after Add", elem)
+				}
+				// Query elements other workers may be adding at the same time.
+				cf.Contains([]byte(fmt.Sprintf("worker-%d-%d", (g+1)%goroutines, i)))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for g := 0; g < goroutines; g++ {
+		for i := 0; i < perWorker; i++ {
+			elem := []byte(fmt.Sprintf("worker-%d-%d", g, i))
+			if !cf.Contains(elem) {
+				t.Fatalf("Expected Contains(%s) to be true after all workers finished", elem)
+			}
+		}
+	}
+}
+
+func TestConcurrentFilterMatchesFilter(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	bf := NewBloomFilter(5000, 4, logger)
+	cf := NewConcurrentFilter(5000, 4, logger)
+	for _, elem := range generateRandomStrings(500, 12) {
+		bf.Add([]byte(elem))
+		cf.Add([]byte(elem))
+	}
+
+	snapshot := cf.Snapshot()
+	for i := range bf.bits {
+		if snapshot.bits[i] != bf.bits[i] {
+			t.Fatalf("Word %d differs: concurrent %x, plain %x", i, snapshot.bits[i], bf.bits[i])
+		}
+	}
+	if cf.FalsePositiveRate() != bf.FalsePositiveRate() {
+		t.Errorf("False positive rates differ: concurrent %v, plain %v", cf.FalsePositiveRate(), bf.FalsePositiveRate())
+	}
+
+	var buf bytes.Buffer
+	if err := cf.Save(&buf); err != nil {
+		t.Fatalf("Failed to save concurrent Bloom filter: %v", err)
+	}
+	loaded := &ConcurrentFilter{}
+	if err := loaded.Load(&buf, logger); err != nil {
+		t.Fatalf("Failed to load concurrent Bloom filter: %v", err)
+	}
+	for _, elem := range []string{"apple", "banana", "cherry"} {
+		if loaded.Contains([]byte(elem)) != bf.Contains([]byte(elem)) {
+			t.Errorf("Mismatch for element %s after load", elem)
+		}
+	}
+}
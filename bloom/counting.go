@@ -0,0 +1,208 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+)
+
+var (
+	// ErrCounterOverflow is returned by CountingFilter.Add when at least one of
+	// the element's counters was already saturated, so its increment was lost
+	ErrCounterOverflow = errors.New("bloom: counter overflow")
+	// ErrNotPresent is returned by CountingFilter.Remove for an element the filter does not contain
+	ErrNotPresent = errors.New("bloom: element not present")
+	// ErrInvalidCounterWidth is returned for counter widths other than 4, 8 or 16 bits
+	ErrInvalidCounterWidth = errors.New("bloom: counter width must be 4, 8 or 16 bits")
+)
+
+// CountingFilter is a counting Bloom filter. Each position holds a small
+// counter instead of a single bit, which makes it possible to remove elements.
+//
+// Counters are packed into 64-bit words. A counter that reaches its maximum
+// value is saturated: further increments are dropped and it is never
+// decremented again, because its true value is no longer known. Saturated
+// counters can only cause false positives, never false negatives.
+type CountingFilter struct {
+	counters     []uint64
+	counterWidth uint
+	size         uint
+	numHashFuncs uint
+	saturated    uint
+	logger       *slog.Logger
+}
+
+// NewCountingFilter creates a new counting Bloom filter with the given size,
+// number of hash functions and counter width in bits (4, 8 or 16). A nil
+// logger discards log output.
+func NewCountingFilter(size uint, numHashFuncs uint, counterWidth uint, logger *slog.Logger) (*CountingFilter, error) {
+	if counterWidth != 4 && counterWidth != 8 && counterWidth != 16 {
+		return nil, fmt.Errorf("%w: got %d", ErrInvalidCounterWidth, counterWidth)
+	}
+
+	perWord := 64 / counterWidth
+	cf := &CountingFilter{
+		counters:     make([]uint64, (size+perWord-1)/perWord),
+		counterWidth: counterWidth,
+		size:         size,
+		numHashFuncs: numHashFuncs,
+		logger:       orDiscard(logger),
+	}
+
+	cf.logger.Info("Created new counting Bloom filter", "size", size, "numHashFuncs", numHashFuncs, "counterWidth", counterWidth)
+	return cf, nil
+}
+
+// Add adds an element to the counting Bloom filter. The element is always
+// added; ErrCounterOverflow reports that one of its counters was already
+// saturated.
+func (cf *CountingFilter) Add(element []byte) error {
+	h1, h2 := hashElement(element)
+	overflow := false
+	for i := uint(0); i < cf.numHashFuncs; i++ {
+		index := location(h1, h2, i, cf.size)
+		value := cf.counter(index)
+		if value == cf.maxCount() {
+			overflow = true
+			continue
+		}
+		cf.setCounter(index, value+1)
+		if value+1 == cf.maxCount() {
+			cf.saturated++
+			cf.logger.Warn("Counter saturated in counting Bloom filter", "index", index)
+		}
+	}
+
+	if overflow {
+		cf.logger.Warn("Counter overflow in counting Bloom filter", "element", string(element))
+		return ErrCounterOverflow
+	}
+	cf.logger.Info("Added element to counting Bloom filter", "element", string(element))
+	return nil
+}
+
+// Remove removes one occurrence of an element from the counting Bloom filter.
+// It returns ErrNotPresent, leaving the filter unchanged, if the element is
+// definitely not in the filter. Removing an element that was never added but
+// tests as a false positive corrupts the filter, as with any counting filter.
+func (cf *CountingFilter) Remove(element []byte) error {
+	if !cf.Contains(element) {
+		return ErrNotPresent
+	}
+
+	h1, h2 := hashElement(element)
+	for i := uint(0); i < cf.numHashFuncs; i++ {
+		index := location(h1, h2, i, cf.size)
+		if value := cf.counter(index); value != cf.maxCount() {
+			cf.setCounter(index, value-1)
+		}
+	}
+	cf.logger.Info("Removed element from counting Bloom filter", "element", string(element))
+	return nil
+}
+
+// Contains checks if an element might be in the counting Bloom filter
+func (cf *CountingFilter) Contains(element []byte) bool {
+	return cf.Count(element) > 0
+}
+
+// Count returns an upper bound on the number of times an element has been
+// added, the minimum of its counters
+func (cf *CountingFilter) Count(element []byte) uint {
+	h1, h2 := hashElement(element)
+	minimum := cf.maxCount()
+	for i := uint(0); i < cf.numHashFuncs && minimum > 0; i++ {
+		minimum = min(minimum, cf.counter(location(h1, h2, i, cf.size)))
+	}
+	return uint(minimum)
+}
+
+// SaturatedCounters returns the number of counters that have reached their maximum value
+func (cf *CountingFilter) SaturatedCounters() uint {
+	return cf.saturated
+}
+
+// FalsePositiveRate calculates the current false positive rate of the counting Bloom filter
+func (cf *CountingFilter) FalsePositiveRate() float64 {
+	nonZero := 0
+	for i := uint64(0); i < uint64(cf.size); i++ {
+		if cf.counter(i) != 0 {
+			nonZero++
+		}
+	}
+	probability := float64(nonZero) / float64(cf.size)
+	return math.Pow(probability, float64(cf.numHashFuncs))
+}
+
+// Save serializes the counting Bloom filter to a writer
+func (cf *CountingFilter) Save(w io.Writer) error {
+	return writeGobFrame(w, kindCounting, struct {
+		Counters     []uint64
+		CounterWidth uint
+		Size         uint
+		NumHash      uint
+	}{
+		Counters:     cf.counters,
+		CounterWidth: cf.counterWidth,
+		Size:         cf.size,
+		NumHash:      cf.numHashFuncs,
+	})
+}
+
+// Load deserializes the counting Bloom filter from a reader
+func (cf *CountingFilter) Load(r io.Reader, logger *slog.Logger) error {
+	var data struct {
+		Counters     []uint64
+		CounterWidth uint
+		Size         uint
+		NumHash      uint
+	}
+	if err := readGobFrame(r, kindCounting, &data); err != nil {
+		return err
+	}
+	if data.CounterWidth != 4 && data.CounterWidth != 8 && data.CounterWidth != 16 {
+		return fmt.Errorf("%w: got %d", ErrInvalidCounterWidth, data.CounterWidth)
+	}
+	if err := checkFilterParams(uint64(data.Size), uint64(data.NumHash)); err != nil {
+		return err
+	}
+	perWord := 64 / data.CounterWidth
+	if uint(len(data.Counters)) != (data.Size+perWord-1)/perWord {
+		return fmt.Errorf("%w: counter array has %d words, want %d for size %d", ErrInvalidFormat, len(data.Counters), (data.Size+perWord-1)/perWord, data.Size)
+	}
+
+	cf.counters = data.Counters
+	cf.counterWidth = data.CounterWidth
+	cf.size = data.Size
+	cf.numHashFuncs = data.NumHash
+	cf.logger = orDiscard(logger)
+	cf.saturated = 0
+	for i := uint64(0); i < uint64(cf.size); i++ {
+		if cf.counter(i) == cf.maxCount() {
+			cf.saturated++
+		}
+	}
+	return nil
+}
+
+// maxCount returns the largest value a counter can hold
+func (cf *CountingFilter) maxCount() uint64 {
+	return 1<<cf.counterWidth - 1
+}
+
+// counter returns the value of counter i
+func (cf *CountingFilter) counter(i uint64) uint64 {
+	perWord := uint64(64 / cf.counterWidth)
+	shift := (i % perWord) * uint64(cf.counterWidth)
+	return (cf.counters[i/perWord] >> shift) & cf.maxCount()
+}
+
+// setCounter stores value in counter i
+func (cf *CountingFilter) setCounter(i uint64, value uint64) {
+	perWord := uint64(64 / cf.counterWidth)
+	shift := (i % perWord) * uint64(cf.counterWidth)
+	word := &cf.counters[i/perWord]
+	*word = *word&^(cf.maxCount()<<shift) | value<<shift
+}
@@ -0,0 +1,160 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCountingFilter(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	for _, width := range []uint{4, 8, 16} {
+		size := OptimalSize(100, 0.01)
+		cf, err := NewCountingFilter(size, OptimalHashFunctions(size, 100), width, logger)
+		if err != nil {
+			t.Fatalf("NewCountingFilter(width=%d) returned error: %v", width, err)
+		}
+
+		for _, elem := range []string{"apple", "banana", "banana", "cherry"} {
+			if err := cf.Add([]byte(elem)); err != nil {
+				t.Fatalf("Add(%s) returned error: %v", elem, err)
+			}
+		}
+
+		if got := cf.Count([]byte("banana")); got != 2 {
+			t.Errorf("width %d: Expected Count(banana) to be 2, but got %d", width, got)
+		}
+		if err := cf.Remove([]byte("apple")); err != nil {
+			t.Errorf("width %d: Remove(apple) returned error: %v", width, err)
+		}
+		if cf.Contains([]byte("apple")) {
+			t.Errorf("width %d: Expected apple to be gone after Remove", width)
+		}
+		if err := cf.Remove([]byte("banana")); err != nil {
+			t.Errorf("width %d: Remove(banana) returned error: %v", width, err)
+		}
+		if !cf.Contains([]byte("banana")) {
+			t.Errorf("width %d: Expected banana to remain after removing one of two copies", width)
+		}
+		if !cf.Contains([]byte("cherry")) {
+			t.Errorf("width %d: Expected cherry to be unaffected by removals", width)
+		}
+		if err := cf.Remove([]byte("durian")); !errors.Is(err, ErrNotPresent) {
+			t.Errorf("width %d: Expected ErrNotPresent removing absent element, got %v", width, err)
+		}
+	}
+}
+
+func TestCountingFilterInvalidWidth(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	for _, width := range []uint{0, 1, 5, 32} {
+		if _, err := NewCountingFilter(100, 3, width, logger); !errors.Is(err, ErrInvalidCounterWidth) {
+			t.Errorf("Expected ErrInvalidCounterWidth for width %d, got %v", width, err)
+		}
+	}
+}
+
+func TestCountingFilterSaturation(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	cf, err := NewCountingFilter(1000, 3, 4, logger)
+	if err != nil {
+		t.Fatalf("NewCountingFilter returned error: %v", err)
+	}
+	elem := []byte("hot-key")
+
+	// A 4-bit counter holds at most 15.
+	for i := 0; i < 15; i++ {
+		if err := cf.Add(elem); err != nil {
+			t.Fatalf("Add #%d returned error: %v", i+1, err)
+		}
+	}
+	if got := cf.SaturatedCounters(); got != 3 {
+		t.Errorf("Expected 3 saturated counters, got %d", got)
+	}
+	if err := cf.Add(elem); !errors.Is(err, ErrCounterOverflow) {
+		t.Errorf("Expected ErrCounterOverflow on 16th Add, got %v", err)
+	}
+
+	// Saturated counters are sticky, so the element can never be removed.
+	for i := 0; i < 20; i++ {
+		if err := cf.Remove(elem); err != nil {
+			t.Fatalf("Remove #%d returned error: %v", i+1, err)
+		}
+	}
+	if got := cf.Count(elem); got != 15 {
+		t.Errorf("Expected saturated Count to stay at 15, got %d", got)
+	}
+}
+
+func TestCountingFilterSaveAndLoad(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	original, err := NewCountingFilter(2000, 5, 8, logger)
+	if err != nil {
+		t.Fatalf("NewCountingFilter returned error: %v", err)
+	}
+	elements := generateRandomStrings(100, 10)
+	for _, elem := range elements {
+		if err := original.Add([]byte(elem)); err != nil {
+			t.Fatalf("Add(%s) returned error: %v", elem, err)
+		}
+	}
+
+	filename := filepath.Join(t.TempDir(), "counting.gob")
+	if err := SaveToFile(original, filename, logger); err != nil {
+		t.Fatalf("Failed to save counting filter: %v", err)
+	}
+	loaded := &CountingFilter{}
+	if err := LoadFromFile(filename, loaded, logger); err != nil {
+		t.Fatalf("Failed to load counting filter: %v", err)
+	}
+
+	for _, elem := range elements {
+		if loaded.Count([]byte(elem)) != original.Count([]byte(elem)) {
+			t.Errorf("Count mismatch for %s: original %d, loaded %d", elem, original.Count([]byte(elem)), loaded.Count([]byte(elem)))
+		}
+	}
+	if loaded.FalsePositiveRate() != original.FalsePositiveRate() {
+		t.Errorf("False positive rates differ: original %v, loaded %v", original.FalsePositiveRate(), loaded.FalsePositiveRate())
+	}
+}
+
+func TestCountingFilterLoadInvalidParameters(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	tests := []struct {
+		name          string
+		size          uint
+		numHash       uint
+		expectedError error
+	}{
+		{"Zero size", 0, 3, ErrInvalidFormat},
+		{"Size overflowing the word count", math.MaxUint, 3, ErrInvalidFormat},
+		{"Zero hash functions", 100, 0, ErrInvalidFormat},
+		{"Valid", 100, 3, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := writeGobFrame(&buf, kindCounting, struct {
+				Counters     []uint64
+				CounterWidth uint
+				Size         uint
+				NumHash      uint
+			}{make([]uint64, 13), 8, tt.size, tt.numHash})
+			if err != nil {
+				t.Fatalf("writeGobFrame() error = %v", err)
+			}
+			if err := (&CountingFilter{}).Load(&buf, logger); !errors.Is(err, tt.expectedError) {
+				t.Errorf("Load() error = %v, expectedError %v", err, tt.expectedError)
+			}
+		})
+	}
+}
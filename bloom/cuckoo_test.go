@@ -0,0 +1,243 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCuckooFilter(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	tests := []struct {
+		name              string
+		expectedElements  int
+		falsePositiveRate float64
+		bucketSize        uint
+	}{
+		{"Default bucket size", 10000, 0.01, DefaultBucketSize},
+		{"Small buckets", 5000, 0.02, 2},
+		{"Large buckets", 10000, 0.001, 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf, err := NewCuckooFilter(tt.expectedElements, tt.falsePositiveRate, tt.bucketSize, logger)
+			if err != nil {
+				t.Fatalf("NewCuckooFilter returned error: %v", err)
+			}
+
+			for i := 0; i < tt.expectedElements; i++ {
+				if err := cf.Insert([]byte(fmt.Sprintf("member-%d", i))); err != nil {
+					t.Fatalf("Insert(member-%d) returned error at load factor %f: %v", i, cf.LoadFactor(), err)
+				}
+			}
+			if cf.Count() != uint(tt.expectedElements) {
+				t.Errorf("Expected Count to be %d, got %d", tt.expectedElements, cf.Count())
+			}
+			for i := 0; i < tt.expectedElements; i++ {
+				if !cf.Contains([]byte(fmt.Sprintf("member-%d", i))) {
+					t.Fatalf("Expected Contains(member-%d) to be true", i)
+				}
+			}
+
+			falsePositives := 0
+			const probes = 100000
+			for i := 0; i < probes; i++ {
+				if cf.Contains([]byte(fmt.Sprintf("probe-%d", i))) {
+					falsePositives++
+				}
+			}
+			actualFPR := float64(falsePositives) / probes
+			t.Logf("Load factor: %f, Actual FPR: %f, Estimated FPR: %f", cf.LoadFactor(), actualFPR, cf.FalsePositiveRate())
+			if actualFPR > tt.falsePositiveRate {
+				t.Errorf("Actual false positive rate %f exceeds target %f", actualFPR, tt.falsePositiveRate)
+			}
+		})
+	}
+}
+
+func TestCuckooFilterDelete(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	cf, err := NewCuckooFilter(1000, 0.001, DefaultBucketSize, logger)
+	if err != nil {
+		t.Fatalf("NewCuckooFilter returned error: %v", err)
+	}
+	elements := generateRandomStrings(500, 10)
+	for _, elem := range elements {
+		if err := cf.Insert([]byte(elem)); err != nil {
+			t.Fatalf("Insert(%s) returned error: %v", elem, err)
+		}
+	}
+
+	for _, elem := range elements[:250] {
+		if err := cf.Delete([]byte(elem)); err != nil {
+			t.Errorf("Delete(%s) returned error: %v", elem, err)
+		}
+	}
+	if cf.Count() != 250 {
+		t.Errorf("Expected Count to be 250 after deletes, got %d", cf.Count())
+	}
+	for _, elem := range elements[250:] {
+		if !cf.Contains([]byte(elem)) {
+			t.Errorf("Expected %s to survive deletion of other elements", elem)
+		}
+	}
+	if err := cf.Delete([]byte("never-inserted")); !errors.Is(err, ErrNotPresent) {
+		t.Errorf("Expected ErrNotPresent deleting absent element, got %v", err)
+	}
+}
+
+func TestCuckooFilterFull(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	cf, err := NewCuckooFilterWithFingerprint(64, 12, DefaultBucketSize, logger)
+	if err != nil {
+		t.Fatalf("NewCuckooFilterWithFingerprint returned error: %v", err)
+	}
+
+	var inserted [][]byte
+	for i := 0; ; i++ {
+		elem := []byte(fmt.Sprintf("member-%d", i))
+		err := cf.Insert(elem)
+		if errors.Is(err, ErrFilterFull) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Insert returned unexpected error: %v", err)
+		}
+		inserted = append(inserted, elem)
+		if uint(len(inserted)) > cf.Capacity() {
+			t.Fatalf("Inserted %d elements into %d slots without ErrFilterFull", len(inserted), cf.Capacity())
+		}
+	}
+
+	t.Logf("Filled %d of %d slots before ErrFilterFull", len(inserted), cf.Capacity())
+	if cf.Count() != uint(len(inserted)) {
+		t.Errorf("Failed insert changed Count: got %d, want %d", cf.Count(), len(inserted))
+	}
+	// The failed insert rolls back its evictions, so nothing is lost.
+	for _, elem := range inserted {
+		if !cf.Contains(elem) {
+			t.Errorf("Expected %s to survive a failed insert", elem)
+		}
+	}
+}
+
+func TestCuckooFilterInvalidParameters(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	if _, err := NewCuckooFilter(0, 0.01, DefaultBucketSize, logger); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter for zero elements, got %v", err)
+	}
+	if _, err := NewCuckooFilter(100, 1.5, DefaultBucketSize, logger); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter for false positive rate above one, got %v", err)
+	}
+	if _, err := NewCuckooFilter(100, 0.000001, DefaultBucketSize, logger); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter when the rate needs more than 16-bit fingerprints, got %v", err)
+	}
+	if _, err := NewCuckooFilterWithFingerprint(100, 8, 0, logger); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter for zero bucket size, got %v", err)
+	}
+}
+
+func TestOptimalFingerprintSize(t *testing.T) {
+	tests := []struct {
+		bucketSize        uint
+		falsePositiveRate float64
+		expectedBits      uint
+	}{
+		{4, 0.03, 9},
+		{4, 0.01, 10},
+		{4, 0.001, 13},
+		{2, 0.01, 9},
+		{8, 0.0001, 18},
+	}
+
+	for _, tt := range tests {
+		if bits := OptimalFingerprintSize(tt.bucketSize, tt.falsePositiveRate); bits != tt.expectedBits {
+			t.Errorf("OptimalFingerprintSize(%d, %v) = %d, want %d", tt.bucketSize, tt.falsePositiveRate, bits, tt.expectedBits)
+		}
+	}
+}
+
+func TestCuckooFilterSaveAndLoad(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	original, err := NewCuckooFilter(1000, 0.01, DefaultBucketSize, logger)
+	if err != nil {
+		t.Fatalf("NewCuckooFilter returned error: %v", err)
+	}
+	elements := generateRandomStrings(800, 10)
+	for _, elem := range elements {
+		if err := original.Insert([]byte(elem)); err != nil {
+			t.Fatalf("Insert(%s) returned error: %v", elem, err)
+		}
+	}
+
+	filename := filepath.Join(t.TempDir(), "cuckoo.gob")
+	if err := SaveToFile(original, filename, logger); err != nil {
+		t.Fatalf("Failed to save cuckoo filter: %v", err)
+	}
+	loaded := &CuckooFilter{}
+	if err := LoadFromFile(filename, loaded, logger); err != nil {
+		t.Fatalf("Failed to load cuckoo filter: %v", err)
+	}
+
+	if loaded.Count() != original.Count() || loaded.LoadFactor() != original.LoadFactor() {
+		t.Errorf("Loaded filter stats differ: count %d vs %d", loaded.Count(), original.Count())
+	}
+	for _, elem := range elements {
+		if !loaded.Contains([]byte(elem)) {
+			t.Errorf("Expected loaded filter to contain %s", elem)
+		}
+	}
+	if err := loaded.Delete([]byte(elements[0])); err != nil {
+		t.Errorf("Delete on loaded filter returned error: %v", err)
+	}
+}
+
+func TestCuckooFilterLoadInvalidParameters(t *testing.T) {
+	tests := []struct {
+		name            string
+		fingerprintBits uint
+		bucketSize      uint
+	}{
+		{"Zero fingerprint bits", 0, 4},
+		{"Fingerprint wider than a slot", 17, 4},
+		{"Zero bucket size", 8, 0},
+		{"Bucket size too large", 8, 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The frame is well formed and its checksum valid; only the
+			// parameters are out of range.
+			const numBuckets = 16
+			var buf bytes.Buffer
+			err := writeGobFrame(&buf, kindCuckoo, struct {
+				Buckets         []uint16
+				NumBuckets      uint
+				BucketSize      uint
+				FingerprintBits uint
+				Count           uint
+			}{
+				Buckets:         make([]uint16, numBuckets*tt.bucketSize),
+				NumBuckets:      numBuckets,
+				BucketSize:      tt.bucketSize,
+				FingerprintBits: tt.fingerprintBits,
+			})
+			if err != nil {
+				t.Fatalf("writeGobFrame() error = %v", err)
+			}
+			if err := (&CuckooFilter{}).Load(&buf, slog.New(discardHandler{})); !errors.Is(err, ErrInvalidFormat) {
+				t.Errorf("Load() error = %v, expectedError %v", err, ErrInvalidFormat)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package bloom
+
+import (
+	"math"
+	"math/bits"
+)
+
+// EstimateCount estimates how many distinct elements have been added to the
+// filter from the fraction of bits set, using the formula of Swamidass and
+// Baldi:
+//
+//	n ≈ -(m / k) · ln(1 - X / m)
+//
+// where m is the size, k the number of hash functions and X the number of set
+// bits. Unlike a counter kept alongside the filter, it works for filters
+// loaded from disk or merged with Union, and adding an element twice does not
+// count it twice. The estimate is accurate to a few percent up to the capacity
+// the filter was sized for and degrades as the filter saturates; a filter with
+// every bit set returns +Inf.
+func (bf *Filter) EstimateCount() float64 {
+	return estimateCount(bf.bits.count(), bf.size, bf.numHashFuncs)
+}
+
+// EstimateUnionCount estimates the number of distinct elements added to a or b
+// without building their union. The filters must be Compatible.
+func EstimateUnionCount(a, b *Filter) (float64, error) {
+	if err := a.Compatible(b); err != nil {
+		return 0, err
+	}
+	setBits := 0
+	for i, w := range a.bits {
+		setBits += bits.OnesCount64(w | b.bits[i])
+	}
+	return estimateCount(uint(setBits), a.size, a.numHashFuncs), nil
+}
+
+// EstimateIntersectionCount estimates the number of distinct elements added to
+// both a and b by inclusion–exclusion, |A| + |B| - |A ∪ B|, with each term
+// estimated as in EstimateCount. Counting the bits of the intersected filter
+// instead would overestimate, since bits set by different elements in each
+// filter survive an AND (see Intersect). The errors of the three estimates add
+// up, so the result is only meaningful when the intersection is not small
+// compared with the union. It is never negative. If every bit of the union is
+// set, as it is when either filter is full, the union estimate is +Inf and the
+// difference is undefined; the result is then +Inf, as EstimateCount returns
+// for a full filter. The filters must be Compatible.
+func EstimateIntersectionCount(a, b *Filter) (float64, error) {
+	union, err := EstimateUnionCount(a, b)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsInf(union, 1) {
+		return union, nil
+	}
+	return max(a.EstimateCount()+b.EstimateCount()-union, 0), nil
+}
+
+// estimateCount applies the Swamidass–Baldi formula to setBits of size bits
+func estimateCount(setBits, size, numHashFuncs uint) float64 {
+	if setBits >= size {
+		return math.Inf(1)
+	}
+	m, k := float64(size), float64(numHashFuncs)
+	return -m / k * math.Log1p(-float64(setBits)/m)
+}
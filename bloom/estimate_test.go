@@ -0,0 +1,151 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestEstimateCount(t *testing.T) {
+	const capacity = 10000
+	size := OptimalSize(capacity, 0.01)
+	numHash := OptimalHashFunctions(size, capacity)
+
+	tests := []struct {
+		name      string
+		inserted  int
+		maxRelErr float64
+	}{
+		{"Empty", 0, 0},
+		{"Tenth of capacity", 1000, 0.03},
+		{"Half of capacity", 5000, 0.03},
+		{"At capacity", 10000, 0.03},
+		{"Twice capacity", 20000, 0.05},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bf := NewBloomFilter(size, numHash, nil)
+			for i := 0; i < tt.inserted; i++ {
+				bf.Add([]byte(fmt.Sprintf("element-%d", i)))
+			}
+			// Duplicates must not be counted again.
+			for i := 0; i < tt.inserted/2; i++ {
+				bf.Add([]byte(fmt.Sprintf("element-%d", i)))
+			}
+
+			estimate := bf.EstimateCount()
+			if math.Abs(estimate-float64(tt.inserted)) > tt.maxRelErr*float64(tt.inserted) {
+				t.Errorf("EstimateCount() = %.1f, want %d within %.0f%%", estimate, tt.inserted, 100*tt.maxRelErr)
+			}
+		})
+	}
+}
+
+func TestEstimateCountFull(t *testing.T) {
+	bf := NewBloomFilter(64, 1, nil)
+	for i := range bf.bits {
+		bf.bits[i] = math.MaxUint64
+	}
+	if estimate := bf.EstimateCount(); !math.IsInf(estimate, 1) {
+		t.Errorf("EstimateCount() = %v for a full filter, want +Inf", estimate)
+	}
+}
+
+func TestEstimateIntersectionCountFull(t *testing.T) {
+	full := NewBloomFilter(64, 1, nil)
+	for i := range full.bits {
+		full.bits[i] = math.MaxUint64
+	}
+	partial := NewBloomFilter(64, 1, nil)
+	partial.Add([]byte("hello"))
+
+	tests := []struct {
+		name string
+		a, b *Filter
+	}{
+		{"Both full", full, full},
+		{"First full", full, partial},
+		{"Second full", partial, full},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			estimate, err := EstimateIntersectionCount(tt.a, tt.b)
+			if err != nil {
+				t.Fatalf("EstimateIntersectionCount() error = %v", err)
+			}
+			if !math.IsInf(estimate, 1) {
+				t.Errorf("EstimateIntersectionCount() = %v, want +Inf", estimate)
+			}
+		})
+	}
+}
+
+func TestEstimateUnionAndIntersectionCount(t *testing.T) {
+	const capacity = 20000
+	size := OptimalSize(capacity, 0.01)
+	numHash := OptimalHashFunctions(size, capacity)
+
+	tests := []struct {
+		name   string
+		onlyA  int
+		onlyB  int
+		shared int
+	}{
+		{"Disjoint", 5000, 5000, 0},
+		{"Overlapping", 4000, 6000, 3000},
+		{"Identical", 0, 0, 8000},
+		{"Subset", 5000, 0, 5000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewBloomFilter(size, numHash, nil)
+			b := NewBloomFilter(size, numHash, nil)
+			for i := 0; i < tt.onlyA; i++ {
+				a.Add([]byte(fmt.Sprintf("a-%d", i)))
+			}
+			for i := 0; i < tt.onlyB; i++ {
+				b.Add([]byte(fmt.Sprintf("b-%d", i)))
+			}
+			for i := 0; i < tt.shared; i++ {
+				a.Add([]byte(fmt.Sprintf("shared-%d", i)))
+				b.Add([]byte(fmt.Sprintf("shared-%d", i)))
+			}
+			unionSize := float64(tt.onlyA + tt.onlyB + tt.shared)
+
+			union, err := EstimateUnionCount(a, b)
+			if err != nil {
+				t.Fatalf("EstimateUnionCount() error = %v", err)
+			}
+			if math.Abs(union-unionSize) > 0.03*unionSize {
+				t.Errorf("EstimateUnionCount() = %.1f, want %.0f within 3%%", union, unionSize)
+			}
+
+			// The error of the intersection estimate scales with the union, not
+			// with the intersection itself.
+			intersection, err := EstimateIntersectionCount(a, b)
+			if err != nil {
+				t.Fatalf("EstimateIntersectionCount() error = %v", err)
+			}
+			if math.Abs(intersection-float64(tt.shared)) > 0.05*unionSize {
+				t.Errorf("EstimateIntersectionCount() = %.1f, want %d within 5%% of the union", intersection, tt.shared)
+			}
+			if intersection < 0 {
+				t.Errorf("EstimateIntersectionCount() = %v, want non-negative", intersection)
+			}
+		})
+	}
+}
+
+func TestEstimateCountIncompatible(t *testing.T) {
+	a := NewBloomFilter(1000, 3, nil)
+	b := NewBloomFilter(1000, 4, nil)
+	if _, err := EstimateUnionCount(a, b); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("EstimateUnionCount() error = %v, expectedError %v", err, ErrIncompatible)
+	}
+	if _, err := EstimateIntersectionCount(a, b); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("EstimateIntersectionCount() error = %v, expectedError %v", err, ErrIncompatible)
+	}
+}
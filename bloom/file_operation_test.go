@@ -2,19 +2,26 @@ package bloom
 
 import (
 	"errors"
-	"hash"
+	"io"
+	"io/fs"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
 )
 
 func TestSaveFilterToFile(t *testing.T) {
 	defer cleanup(t)
 	bf := &Filter{
-		bitArray:  []bool{true, false, true},
-		size:      3,
-		hashFuncs: make([]hash.Hash64, 2),
-		logger:    slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		bits:         bitset{0b101},
+		size:         3,
+		numHashFuncs: 2,
+		hasher:       NewFNV1aHasher(),
+		logger:       slog.New(slog.NewTextHandler(os.Stdout, nil)),
 	}
 
 	tests := []struct {
@@ -60,10 +67,11 @@ func TestLoadFilterFromFile(t *testing.T) {
 
 	// Create a valid Bloom filter file for testing
 	validFilter := &Filter{
-		bitArray:  []bool{true, false, true},
-		size:      3,
-		hashFuncs: make([]hash.Hash64, 2),
-		logger:    logger,
+		bits:         bitset{0b101},
+		size:         3,
+		numHashFuncs: 2,
+		hasher:       NewFNV1aHasher(),
+		logger:       logger,
 	}
 	validFilename := "valid_test.gob"
 	err := SaveFilterToFile(validFilter, validFilename, logger)
@@ -108,6 +116,235 @@ func TestLoadFilterFromFile(t *testing.T) {
 	}
 }
 
+func TestMemFileOperations(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	ops := NewMemFileOperations()
+
+	bf := NewBloomFilter(1000, 4, logger)
+	bf.Add([]byte("hello"))
+	if err := SaveFilterToFileWith(ops, bf, "filters/hello.bin", logger); err != nil {
+		t.Fatalf("SaveFilterToFileWith() error = %v", err)
+	}
+
+	loaded, err := LoadFilterFromFileWith(ops, "filters/hello.bin", logger)
+	if err != nil {
+		t.Fatalf("LoadFilterFromFileWith() error = %v", err)
+	}
+	if !loaded.Contains([]byte("hello")) {
+		t.Errorf("Expected loaded filter to contain hello")
+	}
+
+	if _, err := LoadFilterFromFileWith(ops, "filters/missing.bin", logger); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadFilterFromFileWith() error = %v, expectedError %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestSaveToFileWithInjectedErrors(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	bf := NewBloomFilter(100, 3, logger)
+	errCreate := errors.New("create failed")
+	errWrite := errors.New("write failed")
+	errClose := errors.New("close failed")
+
+	tests := []struct {
+		name          string
+		setup         func(ops *MockFileOperations, file *MockWriteCloser)
+		expectedError error
+	}{
+		{
+			name: "Create error",
+			setup: func(ops *MockFileOperations, _ *MockWriteCloser) {
+				ops.EXPECT().Create("filter.bin").Return(nil, errCreate)
+			},
+			expectedError: errCreate,
+		},
+		{
+			name: "Write error",
+			setup: func(ops *MockFileOperations, file *MockWriteCloser) {
+				ops.EXPECT().Create("filter.bin").Return(file, nil)
+				file.EXPECT().Write(gomock.Any()).Return(0, errWrite)
+				file.EXPECT().Close().Return(nil)
+			},
+			expectedError: errWrite,
+		},
+		{
+			name: "Close error",
+			setup: func(ops *MockFileOperations, file *MockWriteCloser) {
+				ops.EXPECT().Create("filter.bin").Return(file, nil)
+				file.EXPECT().Write(gomock.Any()).DoAndReturn(func(p []byte) (int, error) { return len(p), nil }).AnyTimes()
+				file.EXPECT().Close().Return(errClose)
+			},
+			expectedError: errClose,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			ops := NewMockFileOperations(ctrl)
+			file := NewMockWriteCloser(ctrl)
+			tt.setup(ops, file)
+
+			err := SaveFilterToFileWith(ops, bf, "filter.bin", logger)
+			if !errors.Is(err, tt.expectedError) {
+				t.Errorf("SaveFilterToFileWith() error = %v, expectedError %v", err, tt.expectedError)
+			}
+		})
+	}
+}
+
+func TestLoadFromFileWithInjectedErrors(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	errRead := errors.New("read failed")
+
+	ctrl := gomock.NewController(t)
+	ops := NewMockFileOperations(ctrl)
+	file := NewMockReadCloser(ctrl)
+	ops.EXPECT().Open("filter.bin").Return(file, nil)
+	file.EXPECT().Read(gomock.Any()).Return(0, errRead)
+	file.EXPECT().Close().Return(nil)
+
+	filter, err := LoadFilterFromFileWith(ops, "filter.bin", logger)
+	if !errors.Is(err, errRead) {
+		t.Errorf("LoadFilterFromFileWith() error = %v, expectedError %v", err, errRead)
+	}
+	if filter != nil {
+		t.Errorf("LoadFilterFromFileWith() returned a filter on error")
+	}
+}
+
+// failingPersister writes some bytes and then fails, like a save interrupted midway
+type failingPersister struct{ err error }
+
+func (p failingPersister) Save(w io.Writer) error {
+	if _, err := w.Write([]byte("partial")); err != nil {
+		return err
+	}
+	return p.err
+}
+
+func (p failingPersister) Load(io.Reader, *slog.Logger) error { return p.err }
+
+func TestSaveToFileIsAtomic(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "filter.bin")
+
+	bf := NewBloomFilter(1000, 4, logger)
+	bf.Add([]byte("hello"))
+	if err := SaveFilterToFile(bf, filename, logger); err != nil {
+		t.Fatalf("SaveFilterToFile() error = %v", err)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Mode().Perm() != 0o644 {
+		t.Errorf("Expected new file mode 0644, got %v", info.Mode().Perm())
+	}
+
+	errSave := errors.New("save interrupted")
+	if err := SaveToFile(failingPersister{errSave}, filename, logger); !errors.Is(err, errSave) {
+		t.Errorf("SaveToFile() error = %v, expectedError %v", err, errSave)
+	}
+
+	// The failed save must leave the previous filter intact and no temporary files behind.
+	loaded, err := LoadFilterFromFile(filename, logger)
+	if err != nil {
+		t.Fatalf("LoadFilterFromFile() after failed save error = %v", err)
+	}
+	if !loaded.Contains([]byte("hello")) {
+		t.Errorf("Expected previous filter to survive a failed save")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 1 {
+		names := make([]string, len(entries))
+		for i, e := range entries {
+			names[i] = e.Name()
+		}
+		t.Errorf("Expected only the target file in %s, found %v", dir, names)
+	}
+}
+
+func TestMemFileOperationsKeepsFileOnFailedSave(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	ops := NewMemFileOperations()
+
+	bf := NewBloomFilter(1000, 4, logger)
+	bf.Add([]byte("hello"))
+	if err := SaveFilterToFileWith(ops, bf, "filter.bin", logger); err != nil {
+		t.Fatalf("SaveFilterToFileWith() error = %v", err)
+	}
+
+	errSave := errors.New("save interrupted")
+	if err := SaveToFileWith(ops, failingPersister{errSave}, "filter.bin", logger); !errors.Is(err, errSave) {
+		t.Errorf("SaveToFileWith() error = %v, expectedError %v", err, errSave)
+	}
+	loaded, err := LoadFilterFromFileWith(ops, "filter.bin", logger)
+	if err != nil {
+		t.Fatalf("LoadFilterFromFileWith() after failed save error = %v", err)
+	}
+	if !loaded.Contains([]byte("hello")) {
+		t.Errorf("Expected previous filter to survive a failed save")
+	}
+}
+
+// abortingFileOperations is a custom FileOperations whose writers implement Aborter
+type abortingFileOperations struct {
+	files   map[string]string
+	aborted int
+}
+
+func (o *abortingFileOperations) Create(name string) (io.WriteCloser, error) {
+	return &abortingFile{ops: o, name: name}, nil
+}
+
+func (o *abortingFileOperations) Open(name string) (io.ReadCloser, error) {
+	data, ok := o.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return io.NopCloser(strings.NewReader(data)), nil
+}
+
+// abortingFile commits its content on Close and discards it on Abort
+type abortingFile struct {
+	ops  *abortingFileOperations
+	name string
+	buf  strings.Builder
+}
+
+func (f *abortingFile) Write(p []byte) (int, error) { return f.buf.Write(p) }
+
+func (f *abortingFile) Close() error {
+	f.ops.files[f.name] = f.buf.String()
+	return nil
+}
+
+func (f *abortingFile) Abort() error {
+	f.ops.aborted++
+	return nil
+}
+
+func TestSaveToFileWithCustomAborter(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	ops := &abortingFileOperations{files: map[string]string{"filter.bin": "previous"}}
+
+	errSave := errors.New("save interrupted")
+	if err := SaveToFileWith(ops, failingPersister{errSave}, "filter.bin", logger); !errors.Is(err, errSave) {
+		t.Errorf("SaveToFileWith() error = %v, expectedError %v", err, errSave)
+	}
+	if ops.aborted != 1 {
+		t.Errorf("Expected the failed save to call Abort once, got %d calls", ops.aborted)
+	}
+	if ops.files["filter.bin"] != "previous" {
+		t.Errorf("Expected the failed save to leave the file untouched, got %q", ops.files["filter.bin"])
+	}
+}
+
 func cleanup(t *testing.T) {
 	files := []string{"test.gob", "error.gob", "write_error.gob", "read_error.gob", "valid_test.gob"}
 	for _, file := range files {
@@ -116,3 +353,98 @@ func cleanup(t *testing.T) {
 		}
 	}
 }
+
+// membershipFilter is a persistable filter that can be queried
+type membershipFilter interface {
+	Persister
+	Contains(element []byte) bool
+}
+
+func TestNilLogger(t *testing.T) {
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	element := []byte("hello")
+
+	// Every filter is built, filled, saved and loaded with a nil logger.
+	bf := NewBloomFilter(1000, 4, nil)
+	bf.Add(element)
+	concurrent := NewConcurrentFilter(1000, 4, nil)
+	concurrent.Add(element)
+	counting, err := NewCountingFilter(1000, 4, 8, nil)
+	must(err)
+	must(counting.Add(element))
+	scalable, err := NewScalableFilter(100, 0.01, 2, 0.85, nil)
+	must(err)
+	scalable.Add(element)
+	cuckoo, err := NewCuckooFilter(100, 0.01, 4, nil)
+	must(err)
+	must(cuckoo.Insert(element))
+	partitioned := NewPartitionedFilter(1024, 4, nil)
+	partitioned.Add(element)
+	blocked, err := NewBlockedFilter(1024, 4, CacheLineBlock, nil)
+	must(err)
+	blocked.Add(element)
+	splitBlock, err := NewSplitBlockFilter(1024, nil)
+	must(err)
+	splitBlock.Add(element)
+	quotient, err := NewQuotientFilter(8, 8, nil)
+	must(err)
+	must(quotient.Add(element))
+	stable, err := NewStableFilter(1000, 3, 2, 10, nil)
+	must(err)
+	stable.Add(element)
+	window, err := NewSlidingWindowFilter(time.Minute, time.Second, 1000, 4, nil, nil)
+	must(err)
+	window.Add(element)
+
+	tests := []struct {
+		name   string
+		filter membershipFilter
+		empty  membershipFilter
+	}{
+		{"Filter", bf, &Filter{}},
+		{"ConcurrentFilter", concurrent, &ConcurrentFilter{}},
+		{"CountingFilter", counting, &CountingFilter{}},
+		{"ScalableFilter", scalable, &ScalableFilter{}},
+		{"CuckooFilter", cuckoo, &CuckooFilter{}},
+		{"PartitionedFilter", partitioned, &PartitionedFilter{}},
+		{"BlockedFilter", blocked, &BlockedFilter{}},
+		{"SplitBlockFilter", splitBlock, &SplitBlockFilter{}},
+		{"QuotientFilter", quotient, &QuotientFilter{}},
+		{"StableFilter", stable, &StableFilter{}},
+		{"SlidingWindowFilter", window, &SlidingWindowFilter{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.filter.Contains(element) {
+				t.Fatalf("Expected filter to contain %s", element)
+			}
+			ops := NewMemFileOperations()
+			if err := SaveToFileWith(ops, tt.filter, "filter.bin", nil); err != nil {
+				t.Fatalf("SaveToFileWith() error = %v", err)
+			}
+			if err := LoadFromFileWith(ops, "filter.bin", tt.empty, nil); err != nil {
+				t.Fatalf("LoadFromFileWith() error = %v", err)
+			}
+			if !tt.empty.Contains(element) {
+				t.Errorf("Expected loaded filter to contain %s", element)
+			}
+		})
+	}
+
+	filename := filepath.Join(t.TempDir(), "filter.bin")
+	if err := SaveFilterToFile(bf, filename, nil); err != nil {
+		t.Fatalf("SaveFilterToFile() error = %v", err)
+	}
+	loaded, err := LoadFilterFromFile(filename, nil)
+	if err != nil {
+		t.Fatalf("LoadFilterFromFile() error = %v", err)
+	}
+	if !loaded.Contains(element) {
+		t.Errorf("Expected loaded filter to contain %s", element)
+	}
+}
@@ -1,30 +1,250 @@
 package bloom
 
+//go:generate mockgen -destination=mock_file_operations.go -package=bloom github.com/sbshah97/bloom-filters/bloom FileOperations
+//go:generate mockgen -destination=mock_io.go -package=bloom io ReadCloser,WriteCloser
+
 import (
+	"bytes"
+	"io"
+	"io/fs"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"sync"
 )
 
+// Persister is implemented by every filter in this package that can be
+// serialized with Save and restored with Load
+type Persister interface {
+	Save(w io.Writer) error
+	Load(r io.Reader, logger *slog.Logger) error
+}
+
+// FileOperations abstracts the file system used to save and load filters
+type FileOperations interface {
+	// Create creates or truncates the named file for writing. If the returned
+	// writer also implements Aborter, a failed save calls Abort instead of
+	// Close, so an implementation that only commits the file on Close can save
+	// atomically. A writer that does not implement Aborter is closed after a
+	// failed save and may be left with partial content.
+	Create(name string) (io.WriteCloser, error)
+	// Open opens the named file for reading
+	Open(name string) (io.ReadCloser, error)
+}
+
+// OSFileOperations implements FileOperations with the operating system's file system.
+// Files are replaced atomically: Create writes to a temporary file in the same
+// directory, and Close flushes it to stable storage and renames it over the
+// target, so a crash or failed save never leaves a partially written file in
+// place of the previous one.
+type OSFileOperations struct{}
+
+// Create returns a writer to a temporary file that replaces the named file when closed.
+// Calling Abort instead of Close discards the temporary file and leaves the named file untouched.
+func (OSFileOperations) Create(name string) (io.WriteCloser, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	// CreateTemp uses mode 0600; keep the mode of the file being replaced, or
+	// use the usual mode for new files.
+	mode := fs.FileMode(0o644)
+	if info, err := os.Stat(name); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return nil, err
+	}
+	return &atomicFile{File: tmp, name: name}, nil
+}
+
+// Open opens the named file with os.Open
+func (OSFileOperations) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+// Aborter is implemented by writers returned from FileOperations.Create that
+// can discard what was written instead of committing it on Close
+type Aborter interface {
+	// Abort discards everything written and releases the writer. The named
+	// file is left as it was before Create.
+	Abort() error
+}
+
+// atomicFile is a temporary file that is renamed over its target on Close
+type atomicFile struct {
+	*os.File
+	name string
+}
+
+// Close syncs the temporary file, renames it over the target and syncs the
+// directory so the rename itself survives a crash
+func (f *atomicFile) Close() error {
+	if err := f.File.Sync(); err != nil {
+		_ = f.Abort()
+		return err
+	}
+	if err := f.File.Close(); err != nil {
+		_ = os.Remove(f.File.Name())
+		return err
+	}
+	if err := os.Rename(f.File.Name(), f.name); err != nil {
+		_ = os.Remove(f.File.Name())
+		return err
+	}
+	return syncDir(filepath.Dir(f.name))
+}
+
+// Abort closes and removes the temporary file without touching the target
+func (f *atomicFile) Abort() error {
+	_ = f.File.Close()
+	return os.Remove(f.File.Name())
+}
+
+// syncDir flushes a directory's entries to stable storage
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// MemFileOperations implements FileOperations in memory. It is safe for
+// concurrent use. Like OSFileOperations, a file is replaced only when the
+// writer returned by Create is closed, and Open returns an error wrapping
+// fs.ErrNotExist for files that were never written.
+type MemFileOperations struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// NewMemFileOperations creates an empty in-memory file system
+func NewMemFileOperations() *MemFileOperations {
+	return &MemFileOperations{files: make(map[string][]byte)}
+}
+
+// Create returns a writer that replaces the named in-memory file when closed
+func (m *MemFileOperations) Create(name string) (io.WriteCloser, error) {
+	return &memFile{ops: m, name: name}, nil
+}
+
+// Open opens the named in-memory file for reading
+func (m *MemFileOperations) Open(name string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// memFile buffers writes to an in-memory file until it is closed
+type memFile struct {
+	ops    *MemFileOperations
+	name   string
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrClosed}
+	}
+	return f.buf.Write(p)
+}
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	f.ops.mu.Lock()
+	defer f.ops.mu.Unlock()
+	f.ops.files[f.name] = f.buf.Bytes()
+	return nil
+}
+
+// Abort discards the buffered writes
+func (f *memFile) Abort() error {
+	f.closed = true
+	f.buf.Reset()
+	return nil
+}
+
 // SaveFilterToFile saves a Bloom filter to a file
 func SaveFilterToFile(bf *Filter, filename string, logger *slog.Logger) error {
-	file, err := os.Create(filename)
+	return SaveToFile(bf, filename, logger)
+}
+
+// LoadFilterFromFile loads a Bloom filter from a file
+func LoadFilterFromFile(filename string, logger *slog.Logger) (*Filter, error) {
+	return LoadFilterFromFileWith(OSFileOperations{}, filename, logger)
+}
+
+// SaveFilterToFileWith saves a Bloom filter to a file created through ops
+func SaveFilterToFileWith(ops FileOperations, bf *Filter, filename string, logger *slog.Logger) error {
+	return SaveToFileWith(ops, bf, filename, logger)
+}
+
+// LoadFilterFromFileWith loads a Bloom filter from a file opened through ops
+func LoadFilterFromFileWith(ops FileOperations, filename string, logger *slog.Logger) (*Filter, error) {
+	loadedBF := &Filter{}
+	if err := LoadFromFileWith(ops, filename, loadedBF, logger); err != nil {
+		return nil, err
+	}
+
+	return loadedBF, nil
+}
+
+// SaveToFile saves any filter implementing Persister to a file
+func SaveToFile(p Persister, filename string, logger *slog.Logger) error {
+	return SaveToFileWith(OSFileOperations{}, p, filename, logger)
+}
+
+// LoadFromFile loads a filter implementing Persister from a file, replacing its contents
+func LoadFromFile(filename string, p Persister, logger *slog.Logger) error {
+	return LoadFromFileWith(OSFileOperations{}, filename, p, logger)
+}
+
+// SaveToFileWith saves any filter implementing Persister to a file created through ops.
+// Errors from writing and from closing the file are both returned. If the
+// save fails and the writer implements Aborter, the partial file is aborted
+// rather than committed; otherwise it is closed with whatever was written.
+func SaveToFileWith(ops FileOperations, p Persister, filename string, logger *slog.Logger) error {
+	logger = orDiscard(logger)
+	file, err := ops.Create(filename)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			logger.Error("Failed to close file", "error", err)
+
+	if err := p.Save(file); err != nil {
+		if a, ok := file.(Aborter); ok {
+			if abortErr := a.Abort(); abortErr != nil {
+				logger.Error("Failed to discard partially written file", "error", abortErr)
+			}
+		} else if closeErr := file.Close(); closeErr != nil {
+			logger.Error("Failed to close file", "error", closeErr)
 		}
-	}()
+		return err
+	}
 
-	return bf.Save(file)
+	return file.Close()
 }
 
-// LoadFilterFromFile loads a Bloom filter from a file
-func LoadFilterFromFile(filename string, logger *slog.Logger) (*Filter, error) {
-	file, err := os.Open(filename)
+// LoadFromFileWith loads a filter implementing Persister from a file opened through ops, replacing its contents
+func LoadFromFileWith(ops FileOperations, filename string, p Persister, logger *slog.Logger) error {
+	logger = orDiscard(logger)
+	file, err := ops.Open(filename)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer func() {
 		if err := file.Close(); err != nil {
@@ -32,11 +252,5 @@ func LoadFilterFromFile(filename string, logger *slog.Logger) (*Filter, error) {
 		}
 	}()
 
-	loadedBF := &Filter{}
-	err = loadedBF.Load(file, logger)
-	if err != nil {
-		return nil, err
-	}
-
-	return loadedBF, nil
+	return p.Load(file, logger)
 }
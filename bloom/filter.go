@@ -1,9 +1,10 @@
 package bloom
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/gob"
-	"hash"
-	"hash/fnv"
+	"fmt"
 	"io"
 	"log/slog"
 	"math"
@@ -11,67 +12,73 @@ import (
 
 // Filter represents a Bloom filter data structure
 type Filter struct {
-	bitArray  []bool
-	size      uint
-	hashFuncs []hash.Hash64
-	logger    *slog.Logger
+	bits         bitset
+	size         uint
+	numHashFuncs uint
+	hasher       Hasher
+	parallelism  int
+	logger       *slog.Logger
 }
 
-// NewBloomFilter creates a new Bloom filter with the given size and number of hash functions
+// NewBloomFilter creates a new Bloom filter with the given size and number of hash functions.
+// A nil logger discards log output. The size must be positive; New validates all parameters.
 func NewBloomFilter(size uint, numHashFuncs uint, logger *slog.Logger) *Filter {
-	bf := &Filter{
-		bitArray:  make([]bool, size),
-		size:      size,
-		hashFuncs: make([]hash.Hash64, numHashFuncs),
-		logger:    logger,
-	}
+	return NewBloomFilterWithHasher(size, numHashFuncs, NewFNV1aHasher(), logger)
+}
 
-	for i := uint(0); i < numHashFuncs; i++ {
-		bf.hashFuncs[i] = fnv.New64()
+// NewBloomFilterWithHasher creates a new Bloom filter that derives bit positions with the given hasher
+func NewBloomFilterWithHasher(size uint, numHashFuncs uint, hasher Hasher, logger *slog.Logger) *Filter {
+	if hasher == nil {
+		hasher = NewFNV1aHasher()
+	}
+	logger = orDiscard(logger)
+	bf := &Filter{
+		bits:         newBitset(size),
+		size:         size,
+		numHashFuncs: numHashFuncs,
+		hasher:       hasher,
+		logger:       logger,
 	}
 
-	bf.logger.Info("Created new Bloom filter", "size", size, "numHashFuncs", numHashFuncs)
+	bf.logger.Info("Created new Bloom filter", "size", size, "numHashFuncs", numHashFuncs, "hasher", hasher.Scheme())
 	return bf
 }
 
 // Add adds an element to the Bloom filter
 func (bf *Filter) Add(element []byte) {
-	// This loop iterates through all hash functions in the Bloom filter
-	for i, h := range bf.hashFuncs {
-		// Reset the hash function to clear any previous data
-		h.Reset()
-		bf.logger.Debug("Reset hash function", "hashFunc", i)
-
-		// Write the element (as bytes) to the hash function
-		h.Write(element)
-		bf.logger.Debug("Wrote element to hash function", "hashFunc", i, "element", string(element))
-
-		// Calculate the index in the bit array
-		index := h.Sum64() % uint64(bf.size)
+	// The element is hashed exactly once; the two 64-bit halves of the digest
+	// are then combined to derive every bit position (see location).
+	h1, h2 := bf.hasher.Sum128(element)
+	bf.logger.Debug("Hashed element", "element", string(element), "h1", h1, "h2", h2)
+
+	loc := locatorOf(bf.hasher)
+	for i := uint(0); i < bf.numHashFuncs; i++ {
+		// Calculate the index in the bit array for the i-th hash function
+		index := locate(loc, h1, h2, i, bf.size)
 		bf.logger.Debug("Calculated index", "hashFunc", i, "index", index)
 
-		// Set the bit at the calculated index to true
-		bf.bitArray[index] = true
+		// Set the bit at the calculated index
+		bf.bits.set(index)
 		bf.logger.Debug("Set bit in array", "hashFunc", i, "index", index)
 
 		// Sample output for each step (assuming element is "hello" and bf.size is 10):
-		// Step 1 (i=0): index might be 7, bf.bitArray becomes [0 0 0 0 0 0 0 1 0 0]
-		// Step 2 (i=1): index might be 2, bf.bitArray becomes [0 0 1 0 0 0 0 1 0 0]
-		// Step 3 (i=2): index might be 7 again, bf.bitArray stays [0 0 1 0 0 0 0 1 0 0]
+		// Step 1 (i=0): index might be 7, bf.bits becomes [0 0 0 0 0 0 0 1 0 0]
+		// Step 2 (i=1): index might be 2, bf.bits becomes [0 0 1 0 0 0 0 1 0 0]
+		// Step 3 (i=2): index might be 7 again, bf.bits stays [0 0 1 0 0 0 0 1 0 0]
 		// ... and so on for each hash function
 	}
-	// After all hash functions, bf.bitArray might look like [0 0 1 0 1 0 0 1 1 0]
+	// After all hash functions, bf.bits might look like [0 0 1 0 1 0 0 1 1 0]
 	// This means bits at indices 2, 4, 7, and 8 are set for the element "hello"
 	bf.logger.Info("Added element to Bloom filter", "element", string(element))
 }
 
 // Contains checks if an element might be in the Bloom filter
 func (bf *Filter) Contains(element []byte) bool {
-	for i, h := range bf.hashFuncs {
-		h.Reset()
-		h.Write(element)
-		index := h.Sum64() % uint64(bf.size)
-		if !bf.bitArray[index] {
+	h1, h2 := bf.hasher.Sum128(element)
+	loc := locatorOf(bf.hasher)
+	for i := uint(0); i < bf.numHashFuncs; i++ {
+		index := locate(loc, h1, h2, i, bf.size)
+		if !bf.bits.test(index) {
 			bf.logger.Debug("Element not found in Bloom filter", "element", string(element), "hashFunc", i)
 			return false
 		}
@@ -82,47 +89,183 @@ func (bf *Filter) Contains(element []byte) bool {
 
 // FalsePositiveRate calculates the current false positive rate of the Bloom filter
 func (bf *Filter) FalsePositiveRate() float64 {
-	setBits := 0
-	for _, bit := range bf.bitArray {
-		if bit {
-			setBits++
-		}
-	}
+	setBits := bf.bits.count()
 	probability := float64(setBits) / float64(bf.size)
-	return math.Pow(probability, float64(len(bf.hashFuncs)))
+	return math.Pow(probability, float64(bf.numHashFuncs))
 }
 
-// Save serializes the Bloom filter to a writer
+// Save serializes the Bloom filter to a writer in the framed binary format
+// described in format.go. The key of a keyed filter is not written; see
+// SaveWithKey.
 func (bf *Filter) Save(w io.Writer) error {
-	encoder := gob.NewEncoder(w)
-	return encoder.Encode(struct {
-		BitArray []bool
-		Size     uint
-		NumHash  uint
-	}{
-		BitArray: bf.bitArray,
-		Size:     bf.size,
-		NumHash:  uint(len(bf.hashFuncs)),
+	return bf.save(w, false)
+}
+
+// save writes the framed format, including a secret hasher key only if includeKey is set
+func (bf *Filter) save(w io.Writer, includeKey bool) error {
+	h := frameHeader{
+		kind:    kindFilter,
+		bodyLen: 16 + 8*uint64(len(bf.bits)),
+	}
+	h.scheme, h.flags, h.seed = encodeHasher(bf.hasher, includeKey)
+	return writeFrame(w, h, func(w io.Writer) error {
+		var params [16]byte
+		binary.LittleEndian.PutUint64(params[0:8], uint64(bf.size))
+		binary.LittleEndian.PutUint64(params[8:16], uint64(bf.numHashFuncs))
+		if _, err := w.Write(params[:]); err != nil {
+			return err
+		}
+		return writeWords(w, bf.bits)
 	})
 }
 
-// Load deserializes the Bloom filter from a reader
+// Load deserializes the Bloom filter from a reader. Besides the framed format
+// written by Save, it accepts the gob encoding used by earlier versions. A
+// keyed filter saved without its key returns ErrKeyRequired; use LoadWithKey.
 func (bf *Filter) Load(r io.Reader, logger *slog.Logger) error {
+	return bf.load(r, nil, logger)
+}
+
+// load reads either format, supplying key to a keyed filter saved without one
+func (bf *Filter) load(r io.Reader, key *[16]byte, logger *slog.Logger) error {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return truncated(err)
+	}
+	r = io.MultiReader(bytes.NewReader(magic[:]), r)
+	if magic != frameMagic {
+		return bf.loadGob(r, logger)
+	}
+
+	var size, numHashFuncs uint64
+	var bits bitset
+	var hasher Hasher
+	err := readFrame(r, kindFilter, func(h frameHeader, r io.Reader) error {
+		var err error
+		if hasher, err = decodeHasher(h.scheme, h.flags, h.seed, key); err != nil {
+			return err
+		}
+		var params [16]byte
+		if _, err := io.ReadFull(r, params[:]); err != nil {
+			return err
+		}
+		size = binary.LittleEndian.Uint64(params[0:8])
+		numHashFuncs = binary.LittleEndian.Uint64(params[8:16])
+		if err := checkFilterParams(size, numHashFuncs); err != nil {
+			return err
+		}
+		if h.bodyLen != 16+8*((size+63)/64) {
+			return fmt.Errorf("%w: body of %d bytes does not fit %d bits", ErrInvalidFormat, h.bodyLen, size)
+		}
+		bits, err = readBitset(r, (size+63)/64)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	bf.bits = bits
+	bf.size = uint(size)
+	bf.numHashFuncs = uint(numHashFuncs)
+	bf.hasher = hasher
+	bf.logger = orDiscard(logger)
+	return nil
+}
+
+// loadGob deserializes a Bloom filter saved as a gob by earlier versions. Each
+// version derived positions its own way, so the filter gets a hasher that
+// reproduces them rather than the current default.
+func (bf *Filter) loadGob(r io.Reader, logger *slog.Logger) error {
 	decoder := gob.NewDecoder(r)
+	// BitArray is only present in files written before the bits were packed
+	// into words; gob leaves whichever field is missing from the stream empty.
 	var data struct {
+		Bits     []uint64
 		BitArray []bool
 		Size     uint
 		NumHash  uint
 	}
 	if err := decoder.Decode(&data); err != nil {
+		return truncated(err)
+	}
+	if err := checkFilterParams(uint64(data.Size), uint64(data.NumHash)); err != nil {
 		return err
 	}
-	bf.bitArray = data.BitArray
+	bits := bitset(data.Bits)
+	var hasher Hasher = fnv1aDoubleHasher{}
+	if data.BitArray != nil {
+		bits = packBools(data.BitArray)
+		hasher = fnv1LegacyHasher{}
+	}
+	if uint(len(bits)) != (data.Size+63)/64 {
+		return fmt.Errorf("%w: bit array has %d words, want %d for size %d", ErrInvalidFormat, len(bits), (data.Size+63)/64, data.Size)
+	}
+	bf.bits = bits
 	bf.size = data.Size
-	bf.hashFuncs = make([]hash.Hash64, data.NumHash)
-	for i := uint(0); i < data.NumHash; i++ {
-		bf.hashFuncs[i] = fnv.New64()
+	bf.numHashFuncs = data.NumHash
+	bf.hasher = hasher
+	bf.logger = orDiscard(logger)
+	return nil
+}
+
+// checkFilterParams rejects a size or hash function count read from a file
+// that no filter could have been saved with. A size within 63 of 2^64 would
+// overflow the word count computed from it.
+func checkFilterParams(size, numHashFuncs uint64) error {
+	if size == 0 || size > math.MaxUint64-63 || uint64(uint(size)) != size {
+		return fmt.Errorf("%w: filter size %d is out of range", ErrInvalidFormat, size)
+	}
+	if numHashFuncs == 0 || uint64(uint(numHashFuncs)) != numHashFuncs {
+		return fmt.Errorf("%w: hash function count %d is out of range", ErrInvalidFormat, numHashFuncs)
 	}
-	bf.logger = logger
 	return nil
 }
+
+// hashElement hashes an element with the default hasher. Filter types that do
+// not take a Hasher use it to derive their positions.
+func hashElement(element []byte) (uint64, uint64) {
+	return fnv1aHasher{}.Sum128(element)
+}
+
+// location returns the bit position of the i-th hash function.
+//
+// Rather than running k independent hash functions, positions are derived with
+// Kirsch–Mitzenmacher double hashing: g_i(x) = h1(x) + i*h2(x) mod m. Kirsch and
+// Mitzenmacher showed that this keeps the asymptotic false positive rate of k
+// truly independent hash functions while only hashing the element once.
+//
+// Plain double hashing collapses all k positions onto one bit whenever h2 is a
+// multiple of m, leaving such elements with a single hash function. The cubic
+// term of enhanced double hashing (Dillinger and Manolios, 2004),
+// g_i(x) = h1(x) + i*h2(x) + (i³-i)/6 mod m, keeps the positions apart even
+// then: only the first two coincide.
+func location(h1, h2 uint64, i, size uint) uint64 {
+	n := uint64(i)
+	return (h1 + n*h2 + (n*n*n-n)/6) % uint64(size)
+}
+
+// locatorOf returns the hasher as a Locator, or nil if it leaves positions to location
+func locatorOf(hasher Hasher) Locator {
+	loc, _ := hasher.(Locator)
+	return loc
+}
+
+// locate returns the i-th bit position of the digest h1, h2, derived by loc
+// when it is not nil and by location otherwise
+func locate(loc Locator, h1, h2 uint64, i, size uint) uint64 {
+	if loc != nil {
+		return loc.Location(h1, h2, uint64(i), uint64(size))
+	}
+	return location(h1, h2, i, size)
+}
+
+// fmix64 is the 64-bit finalizer from MurmurHash3. It forces every input bit to
+// affect every output bit.
+func fmix64(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
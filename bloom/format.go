@@ -0,0 +1,283 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+)
+
+// Every filter in this package is saved as a frame: a fixed 40-byte header, a
+// filter-specific body and a CRC-32C trailer. All integers are little-endian.
+//
+//	offset  size  field
+//	0       4     magic "BLMF"
+//	4       2     format version, currently 1
+//	6       1     filter kind (see filterKind)
+//	7       1     hash scheme used to derive bit positions (see HashScheme)
+//	8       1     flags: bit 0 marks a secret hasher key, bit 1 a key left out of the file
+//	9       7     reserved, zero
+//	16      16    hash seed or key (see Hasher.Seed)
+//	32      8     body length in bytes
+//	40      n     body
+//	40+n    4     CRC-32C (Castagnoli) of every preceding byte
+//
+// The Filter body is the bit count and hash function count as uint64s followed
+// by the ceil(size/64) words of the bitset, so the bits of a saved Filter start
+// at the 8-byte aligned offset 56. The PartitionedFilter body has the same
+// layout with the slice size in place of the bit count, and the BlockedFilter
+// body adds the block size between the two counts. The SplitBlockFilter body is
+// the Parquet bitset as is. The bodies of the other filter kinds are gob
+// encoded.
+const (
+	frameVersion    = 1
+	frameHeaderSize = 40
+)
+
+// frameMagic identifies a framed filter file
+var frameMagic = [4]byte{'B', 'L', 'M', 'F'}
+
+// castagnoli is the CRC-32C table used for the frame trailer
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// filterKind identifies the filter type stored in a frame
+type filterKind uint8
+
+const (
+	kindFilter        filterKind = iota + 1 // Filter
+	kindCounting                            // CountingFilter
+	kindScalable                            // ScalableFilter
+	kindCuckoo                              // CuckooFilter
+	kindPartitioned                         // PartitionedFilter
+	kindBlocked                             // BlockedFilter
+	kindSplitBlock                          // SplitBlockFilter
+	kindFuse                                // FuseFilter
+	kindQuotient                            // QuotientFilter
+	kindStable                              // StableFilter
+	kindSlidingWindow                       // SlidingWindowFilter
+	kindIBLT                                // IBLT
+)
+
+var (
+	// ErrTruncated is returned when a saved filter ends before its trailer
+	ErrTruncated = errors.New("bloom: truncated filter data")
+	// ErrChecksumMismatch is returned when a saved filter does not match its CRC-32C trailer
+	ErrChecksumMismatch = errors.New("bloom: checksum mismatch")
+	// ErrUnknownVersion is returned for a format version this package cannot read
+	ErrUnknownVersion = errors.New("bloom: unknown format version")
+	// ErrInvalidFormat is returned when a saved filter is not a frame of the expected kind or its parameters are inconsistent
+	ErrInvalidFormat = errors.New("bloom: invalid filter format")
+)
+
+const (
+	// flagSecretKey marks a hasher key that is only saved when explicitly requested
+	flagSecretKey uint8 = 1 << iota
+	// flagKeyOmitted marks a secret key that was not saved; the seed field is zero
+	flagKeyOmitted
+)
+
+// frameHeader holds the fields of a frame header that vary between filters
+type frameHeader struct {
+	kind    filterKind
+	scheme  HashScheme
+	flags   uint8
+	seed    [16]byte
+	bodyLen uint64
+}
+
+// marshal encodes the header into its 40-byte wire form
+func (h frameHeader) marshal() []byte {
+	buf := make([]byte, frameHeaderSize)
+	copy(buf[0:4], frameMagic[:])
+	binary.LittleEndian.PutUint16(buf[4:6], frameVersion)
+	buf[6] = byte(h.kind)
+	buf[7] = byte(h.scheme)
+	buf[8] = h.flags
+	copy(buf[16:32], h.seed[:])
+	binary.LittleEndian.PutUint64(buf[32:40], h.bodyLen)
+	return buf
+}
+
+// unmarshalFrameHeader decodes and validates a 40-byte header
+func unmarshalFrameHeader(buf []byte, kind filterKind) (frameHeader, error) {
+	if !bytes.Equal(buf[0:4], frameMagic[:]) {
+		return frameHeader{}, fmt.Errorf("%w: bad magic %q", ErrInvalidFormat, buf[0:4])
+	}
+	if version := binary.LittleEndian.Uint16(buf[4:6]); version != frameVersion {
+		return frameHeader{}, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	h := frameHeader{
+		kind:    filterKind(buf[6]),
+		scheme:  HashScheme(buf[7]),
+		flags:   buf[8],
+		bodyLen: binary.LittleEndian.Uint64(buf[32:40]),
+	}
+	copy(h.seed[:], buf[16:32])
+	if h.kind != kind {
+		return frameHeader{}, fmt.Errorf("%w: filter kind %d, want %d", ErrInvalidFormat, h.kind, kind)
+	}
+	return h, nil
+}
+
+// writeFrame writes the header, lets body write exactly h.bodyLen bytes and
+// appends the checksum trailer
+func writeFrame(w io.Writer, h frameHeader, body func(w io.Writer) error) error {
+	crc := crc32.New(castagnoli)
+	cw := &countingWriter{w: io.MultiWriter(w, crc)}
+	if _, err := cw.Write(h.marshal()); err != nil {
+		return err
+	}
+	if err := body(cw); err != nil {
+		return err
+	}
+	if cw.n != frameHeaderSize+h.bodyLen {
+		return fmt.Errorf("bloom: wrote %d body bytes, header declares %d", cw.n-frameHeaderSize, h.bodyLen)
+	}
+	return binary.Write(w, binary.LittleEndian, crc.Sum32())
+}
+
+// readFrame reads a frame of the given kind. body is called with a reader
+// limited to the body and must consume all of it; the checksum is verified
+// after body returns, so body must not publish anything it decodes until
+// readFrame succeeds.
+func readFrame(r io.Reader, kind filterKind, body func(h frameHeader, r io.Reader) error) error {
+	crc := crc32.New(castagnoli)
+	cr := io.TeeReader(r, crc)
+
+	buf := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(cr, buf); err != nil {
+		return truncated(err)
+	}
+	h, err := unmarshalFrameHeader(buf, kind)
+	if err != nil {
+		return err
+	}
+
+	lr := &io.LimitedReader{R: cr, N: int64(h.bodyLen)}
+	if err := body(h, lr); err != nil {
+		return truncated(err)
+	}
+	if lr.N != 0 {
+		return fmt.Errorf("%w: %d unread body bytes", ErrInvalidFormat, lr.N)
+	}
+
+	var trailer uint32
+	if err := binary.Read(r, binary.LittleEndian, &trailer); err != nil {
+		return truncated(err)
+	}
+	if trailer != crc.Sum32() {
+		return fmt.Errorf("%w: stored %08x, computed %08x", ErrChecksumMismatch, trailer, crc.Sum32())
+	}
+	return nil
+}
+
+// writeGobFrame writes v as the gob-encoded body of a frame
+func writeGobFrame(w io.Writer, kind filterKind, v any) error {
+	var body bytes.Buffer
+	if err := gob.NewEncoder(&body).Encode(v); err != nil {
+		return err
+	}
+	h := frameHeader{kind: kind, scheme: HashFNV1a, bodyLen: uint64(body.Len())}
+	return writeFrame(w, h, func(w io.Writer) error {
+		_, err := body.WriteTo(w)
+		return err
+	})
+}
+
+// readGobFrame reads a frame whose body is gob encoded into v. The body is
+// only decoded once its checksum has been verified.
+func readGobFrame(r io.Reader, kind filterKind, v any) error {
+	var body []byte
+	err := readFrame(r, kind, func(h frameHeader, r io.Reader) error {
+		if h.scheme != HashFNV1a {
+			return fmt.Errorf("%w: unsupported hash scheme %d", ErrInvalidFormat, h.scheme)
+		}
+		var err error
+		body, err = io.ReadAll(r)
+		if err == nil && uint64(len(body)) != h.bodyLen {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(body)).Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidFormat, err)
+	}
+	return nil
+}
+
+// writeWords writes words little-endian through a fixed-size buffer, so large
+// bitsets are not copied into one big byte slice
+func writeWords(w io.Writer, words []uint64) error {
+	var buf [4096]byte
+	for len(words) > 0 {
+		n := min(len(words), len(buf)/8)
+		for i, word := range words[:n] {
+			binary.LittleEndian.PutUint64(buf[i*8:], word)
+		}
+		if _, err := w.Write(buf[:n*8]); err != nil {
+			return err
+		}
+		words = words[n:]
+	}
+	return nil
+}
+
+// readWords fills words from little-endian input
+func readWords(r io.Reader, words []uint64) error {
+	var buf [4096]byte
+	for len(words) > 0 {
+		n := min(len(words), len(buf)/8)
+		if _, err := io.ReadFull(r, buf[:n*8]); err != nil {
+			return err
+		}
+		for i := range words[:n] {
+			words[i] = binary.LittleEndian.Uint64(buf[i*8:])
+		}
+		words = words[n:]
+	}
+	return nil
+}
+
+// readBitset reads n words into a new bitset. The bitset grows as the words
+// arrive rather than being allocated up front, so a corrupt header that claims
+// more words than the stream holds ends in a short read, not a huge allocation.
+func readBitset(r io.Reader, n uint64) (bitset, error) {
+	const chunk = 1 << 17 // words, 1 MiB
+	words := make(bitset, 0, min(n, chunk))
+	for uint64(len(words)) < n {
+		start := len(words)
+		m := int(min(n-uint64(start), chunk))
+		words = slices.Grow(words, m)[:start+m]
+		if err := readWords(r, words[start:]); err != nil {
+			return nil, err
+		}
+	}
+	return words, nil
+}
+
+// truncated maps a premature end of input to ErrTruncated
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: %v", ErrTruncated, err)
+	}
+	return err
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += uint64(n)
+	return n, err
+}
@@ -0,0 +1,199 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"math"
+	"os"
+	"testing"
+)
+
+// fuseKeys returns n distinct keys with the given prefix
+func fuseKeys(prefix string, n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("%s-%d", prefix, i))
+	}
+	return keys
+}
+
+func TestBuildFuseFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		numKeys int
+	}{
+		{"No keys", 0},
+		{"One key", 1},
+		{"Few keys", 10},
+		{"Thousand keys", 1000},
+		{"Hundred thousand keys", 100000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := fuseKeys("key", tt.numKeys)
+			ff8, err := BuildFuseFilter(keys)
+			if err != nil {
+				t.Fatalf("BuildFuseFilter() error = %v", err)
+			}
+			ff16, err := BuildFuseFilter16(keys)
+			if err != nil {
+				t.Fatalf("BuildFuseFilter16() error = %v", err)
+			}
+			for _, key := range keys {
+				if !ff8.Contains(key) || !ff16.Contains(key) {
+					t.Fatalf("Expected fuse filters to contain %s", key)
+				}
+			}
+		})
+	}
+}
+
+func TestFuseFilterFalsePositiveRate(t *testing.T) {
+	const n, probes = 100000, 1000000
+	keys := fuseKeys("member", n)
+	ff8, err := BuildFuseFilter(keys)
+	if err != nil {
+		t.Fatalf("BuildFuseFilter() error = %v", err)
+	}
+	ff16, err := BuildFuseFilter16(keys)
+	if err != nil {
+		t.Fatalf("BuildFuseFilter16() error = %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		contains      func([]byte) bool
+		expectedFPR   float64
+		sizeInBytes   int
+		maxBitsPerKey float64
+	}{
+		{"8-bit", ff8.Contains, ff8.FalsePositiveRate(), ff8.SizeInBytes(), 9.6},
+		{"16-bit", ff16.Contains, ff16.FalsePositiveRate(), ff16.SizeInBytes(), 19.2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			falsePositives := 0
+			for i := 0; i < probes; i++ {
+				if tt.contains([]byte(fmt.Sprintf("probe-%d", i))) {
+					falsePositives++
+				}
+			}
+			actualFPR := float64(falsePositives) / probes
+			tolerance := 5 * math.Sqrt(tt.expectedFPR*(1-tt.expectedFPR)/probes)
+			t.Logf("Expected FPR: %f, Actual FPR: %f, bits per key: %.2f", tt.expectedFPR, actualFPR, float64(8*tt.sizeInBytes)/n)
+			if math.Abs(actualFPR-tt.expectedFPR) > tolerance {
+				t.Errorf("Actual false positive rate (%f) differs from expected (%f) by more than tolerance (%f)", actualFPR, tt.expectedFPR, tolerance)
+			}
+			if bitsPerKey := float64(8*tt.sizeInBytes) / n; bitsPerKey > tt.maxBitsPerKey {
+				t.Errorf("Fuse filter uses %.2f bits per key, want at most %.1f", bitsPerKey, tt.maxBitsPerKey)
+			}
+		})
+	}
+}
+
+func TestBuildFuseFilterDuplicateKey(t *testing.T) {
+	keys := append(fuseKeys("key", 1000), []byte("key-42"))
+	_, err := BuildFuseFilter(keys)
+	var duplicate *DuplicateKeyError
+	if !errors.As(err, &duplicate) || string(duplicate.Key) != "key-42" {
+		t.Fatalf("BuildFuseFilter() error = %v, want *DuplicateKeyError for key-42", err)
+	}
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("Expected error to match ErrDuplicateKey")
+	}
+	if _, err := BuildFuseFilter16(keys); !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("BuildFuseFilter16() error = %v, expectedError %v", err, ErrDuplicateKey)
+	}
+}
+
+func TestBuildFuseFilterDeterministic(t *testing.T) {
+	keys := fuseKeys("key", 5000)
+	a, _ := BuildFuseFilter(keys)
+	b, _ := BuildFuseFilter(keys)
+	var bufA, bufB bytes.Buffer
+	if err := a.Save(&bufA); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := b.Save(&bufB); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if !bytes.Equal(bufA.Bytes(), bufB.Bytes()) {
+		t.Errorf("Building from the same keys produced different filters")
+	}
+}
+
+func TestFuseFilterSaveLoad(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	keys := fuseKeys("key", 3000)
+	ff8, _ := BuildFuseFilter(keys)
+	ff16, _ := BuildFuseFilter16(keys)
+
+	var buf8, buf16 bytes.Buffer
+	if err := ff8.Save(&buf8); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := ff16.Save(&buf16); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded8 := &FuseFilter8{}
+	if err := loaded8.Load(bytes.NewReader(buf8.Bytes()), logger); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	loaded16 := &FuseFilter16{}
+	if err := loaded16.Load(bytes.NewReader(buf16.Bytes()), logger); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	for _, key := range keys {
+		if !loaded8.Contains(key) || !loaded16.Contains(key) {
+			t.Fatalf("Expected loaded fuse filters to contain %s", key)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		probe := []byte(fmt.Sprintf("probe-%d", i))
+		if loaded8.Contains(probe) != ff8.Contains(probe) || loaded16.Contains(probe) != ff16.Contains(probe) {
+			t.Fatalf("Loaded fuse filter answers differently for %s", probe)
+		}
+	}
+
+	// A header claiming the largest layout that fits, with a valid checksum,
+	// must be rejected before the fingerprint array is allocated.
+	huge := bytes.Clone(buf8.Bytes())
+	binary.LittleEndian.PutUint32(huge[frameHeaderSize+12:], math.MaxUint32/ff8.segmentLength-2)
+	binary.LittleEndian.PutUint32(huge[len(huge)-4:], crc32.Checksum(huge[:len(huge)-4], castagnoli))
+	// The same layout with a matching body length, cut off after the parameters
+	cut := bytes.Clone(huge[:frameHeaderSize+24])
+	hugeSize := uint64(math.MaxUint32/ff8.segmentLength) * uint64(ff8.segmentLength)
+	binary.LittleEndian.PutUint64(cut[32:40], 24+hugeSize)
+
+	tests := []struct {
+		name          string
+		load          func([]byte) error
+		data          []byte
+		expectedError error
+	}{
+		{"8-bit file as 16-bit filter", (&FuseFilter16{}).loadBytes, buf8.Bytes(), ErrInvalidFormat},
+		{"16-bit file as 8-bit filter", (&FuseFilter8{}).loadBytes, buf16.Bytes(), ErrInvalidFormat},
+		{"Truncated", (&FuseFilter8{}).loadBytes, buf8.Bytes()[:buf8.Len()-10], ErrTruncated},
+		{"Layout larger than the body", (&FuseFilter8{}).loadBytes, huge, ErrInvalidFormat},
+		{"Layout larger than the file", (&FuseFilter8{}).loadBytes, cut, ErrTruncated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.load(tt.data); !errors.Is(err, tt.expectedError) {
+				t.Errorf("Load() error = %v, expectedError %v", err, tt.expectedError)
+			}
+		})
+	}
+}
+
+// loadBytes loads a fuse filter from data, for table-driven error tests
+func (ff *FuseFilter[F]) loadBytes(data []byte) error {
+	return ff.Load(bytes.NewReader(data), nil)
+}
@@ -0,0 +1,223 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+)
+
+// HashScheme identifies a hashing strategy. It is recorded in saved files so
+// Load can rebuild a filter with the same hasher.
+type HashScheme uint8
+
+const (
+	// HashFNV1a is 128-bit FNV-1a with each big-endian half of the digest passed
+	// through the MurmurHash3 finalizer fmix64. It is not plain FNV-1a: another
+	// system reproduces its positions only if it applies the same finalizer.
+	HashFNV1a HashScheme = 1
+	// HashXXHash64 is 64-bit xxHash; the second half of the digest is derived from the first
+	HashXXHash64 HashScheme = 2
+	// HashMurmur3 is the x64 128-bit variant of MurmurHash3
+	HashMurmur3 HashScheme = 3
+	// HashSipHash is SipHash-2-4 with 128-bit output
+	HashSipHash HashScheme = 4
+	// HashFNV1Legacy reproduces the positions of filters saved as gobs of
+	// unpacked bits by the first release: 64-bit FNV-1 of the element modulo m,
+	// the same position for every hash function. Load selects it for those
+	// files and Save keeps it, so old filters go on answering correctly.
+	HashFNV1Legacy HashScheme = 5
+	// HashFNV1aDouble reproduces the positions of filters saved as gobs of
+	// packed bits: the HashFNV1a digest with plain double hashing,
+	// (h1 + i*h2) mod m.
+	HashFNV1aDouble HashScheme = 6
+)
+
+// String returns the name of the hash scheme
+func (s HashScheme) String() string {
+	switch s {
+	case HashFNV1a:
+		return "fnv1a"
+	case HashXXHash64:
+		return "xxhash64"
+	case HashMurmur3:
+		return "murmur3"
+	case HashSipHash:
+		return "siphash"
+	case HashFNV1Legacy:
+		return "fnv1-legacy"
+	case HashFNV1aDouble:
+		return "fnv1a-double"
+	default:
+		return fmt.Sprintf("HashScheme(%d)", uint8(s))
+	}
+}
+
+// Hasher turns an element into a 128-bit digest. Filters split the digest into
+// two 64-bit halves h1 and h2 and derive the i-th bit position, for i from 0
+// to k-1, with enhanced double hashing:
+//
+//	(h1 + i*h2 + (i³-i)/6) mod m
+//
+// where m is the number of bits and all arithmetic wraps modulo 2^64. Two
+// systems produce the same positions when they use the same hasher with the
+// same seed and the same formula.
+//
+// Implementations must be stateless and safe for concurrent use.
+type Hasher interface {
+	// Sum128 returns the two 64-bit halves of the digest of element
+	Sum128(element []byte) (uint64, uint64)
+	// Scheme identifies the algorithm in saved files
+	Scheme() HashScheme
+	// Seed returns the seed or key recorded in saved files alongside Scheme
+	Seed() [16]byte
+}
+
+// Locator is implemented by hashers that derive bit positions from their
+// digest themselves instead of by enhanced double hashing, so a filter can set
+// exactly the bits another system's filter sets. Filter, ConcurrentFilter,
+// MappedFilter and SlidingWindowFilter honour it; the other filter types lay
+// out their bits differently and always use double hashing.
+type Locator interface {
+	Hasher
+	// Location returns the i-th of the k bit positions, in [0, m), of the
+	// element whose digest is h1, h2
+	Location(h1, h2, i, m uint64) uint64
+}
+
+// NewFNV1aHasher returns the default hasher, 128-bit FNV-1a post-mixed with
+// fmix64 (see HashFNV1a)
+func NewFNV1aHasher() Hasher {
+	return fnv1aHasher{}
+}
+
+// NewXXHash64Hasher returns an xxHash64 hasher with the given seed. It is the
+// fastest built-in hasher for long elements.
+func NewXXHash64Hasher(seed uint64) Hasher {
+	return xxhash64Hasher{seed: seed}
+}
+
+// NewMurmur3Hasher returns a MurmurHash3 x64_128 hasher with the given seed
+func NewMurmur3Hasher(seed uint32) Hasher {
+	return murmur3Hasher{seed: seed}
+}
+
+// NewSipHasher returns a SipHash-2-4 hasher with the given 128-bit key. The key
+// is saved with the filter like any other seed; use NewKeyedHasher for a key
+// that must stay secret.
+func NewSipHasher(key [16]byte) Hasher {
+	return sipHasher{key: key}
+}
+
+// hasherForScheme rebuilds the hasher recorded in a saved file
+func hasherForScheme(scheme HashScheme, seed [16]byte) (Hasher, error) {
+	switch scheme {
+	case HashFNV1a:
+		return fnv1aHasher{}, nil
+	case HashXXHash64:
+		return xxhash64Hasher{seed: binary.LittleEndian.Uint64(seed[:8])}, nil
+	case HashMurmur3:
+		return murmur3Hasher{seed: binary.LittleEndian.Uint32(seed[:4])}, nil
+	case HashSipHash:
+		return sipHasher{key: seed}, nil
+	case HashFNV1Legacy:
+		return fnv1LegacyHasher{}, nil
+	case HashFNV1aDouble:
+		return fnv1aDoubleHasher{}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported hash scheme %d", ErrInvalidFormat, scheme)
+	}
+}
+
+// fnv1aHasher is the default hasher
+type fnv1aHasher struct{}
+
+// Sum128 hashes the element with 128-bit FNV-1a. FNV mixes its low bits poorly
+// on short inputs, so each half is passed through a finalizer.
+func (fnv1aHasher) Sum128(element []byte) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write(element)
+	var digest [16]byte
+	sum := h.Sum(digest[:0])
+	return fmix64(binary.BigEndian.Uint64(sum[:8])), fmix64(binary.BigEndian.Uint64(sum[8:]))
+}
+
+func (fnv1aHasher) Scheme() HashScheme { return HashFNV1a }
+
+func (fnv1aHasher) Seed() [16]byte { return [16]byte{} }
+
+// fnv1LegacyHasher reproduces the positions of HashFNV1Legacy filters
+type fnv1LegacyHasher struct{}
+
+// Sum128 returns the 64-bit FNV-1 digest as h1; the first release had no h2
+func (fnv1LegacyHasher) Sum128(element []byte) (uint64, uint64) {
+	h := fnv.New64()
+	h.Write(element)
+	return h.Sum64(), 0
+}
+
+// Location ignores i: the first release ran the same hash function k times
+func (fnv1LegacyHasher) Location(h1, _, _, m uint64) uint64 { return h1 % m }
+
+func (fnv1LegacyHasher) Scheme() HashScheme { return HashFNV1Legacy }
+
+func (fnv1LegacyHasher) Seed() [16]byte { return [16]byte{} }
+
+// fnv1aDoubleHasher reproduces the positions of HashFNV1aDouble filters
+type fnv1aDoubleHasher struct {
+	fnv1aHasher
+}
+
+func (fnv1aDoubleHasher) Location(h1, h2, i, m uint64) uint64 { return (h1 + i*h2) % m }
+
+func (fnv1aDoubleHasher) Scheme() HashScheme { return HashFNV1aDouble }
+
+// xxhash64Hasher hashes with seeded xxHash64
+type xxhash64Hasher struct {
+	seed uint64
+}
+
+// Sum128 runs xxHash64 once; the second half is a remix of the first, which is
+// all double hashing needs from it.
+func (h xxhash64Hasher) Sum128(element []byte) (uint64, uint64) {
+	h1 := xxhash64(element, h.seed)
+	return h1, fmix64(h1 ^ 0x9e3779b97f4a7c15)
+}
+
+func (xxhash64Hasher) Scheme() HashScheme { return HashXXHash64 }
+
+func (h xxhash64Hasher) Seed() [16]byte {
+	var seed [16]byte
+	binary.LittleEndian.PutUint64(seed[:8], h.seed)
+	return seed
+}
+
+// murmur3Hasher hashes with seeded MurmurHash3 x64_128
+type murmur3Hasher struct {
+	seed uint32
+}
+
+func (h murmur3Hasher) Sum128(element []byte) (uint64, uint64) {
+	return murmur3Sum128(element, h.seed)
+}
+
+func (murmur3Hasher) Scheme() HashScheme { return HashMurmur3 }
+
+func (h murmur3Hasher) Seed() [16]byte {
+	var seed [16]byte
+	binary.LittleEndian.PutUint32(seed[:4], h.seed)
+	return seed
+}
+
+// sipHasher hashes with SipHash-2-4-128 under a 128-bit key
+type sipHasher struct {
+	key    [16]byte
+	secret bool
+}
+
+func (h sipHasher) Sum128(element []byte) (uint64, uint64) {
+	return sipHash128(element, binary.LittleEndian.Uint64(h.key[:8]), binary.LittleEndian.Uint64(h.key[8:]))
+}
+
+func (sipHasher) Scheme() HashScheme { return HashSipHash }
+
+func (h sipHasher) Seed() [16]byte { return h.key }
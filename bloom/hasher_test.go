@@ -0,0 +1,207 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestHashReferenceVectors(t *testing.T) {
+	// Vectors from the xxHash, SMHasher and SipHash reference implementations.
+	xxTests := []struct {
+		input    string
+		seed     uint64
+		expected uint64
+	}{
+		{"", 0, 0xef46db3751d8e999},
+		{"a", 0, 0xd24ec4f1a98c6e5b},
+		{"abc", 0, 0x44bc2cf5ad770999},
+		{"Nobody inspects the spammish repetition", 0, 0xfbcea83c8a378bf1},
+	}
+	for _, tt := range xxTests {
+		if got := xxhash64([]byte(tt.input), tt.seed); got != tt.expected {
+			t.Errorf("xxhash64(%q, %d) = %016x, want %016x", tt.input, tt.seed, got, tt.expected)
+		}
+	}
+
+	murmurTests := []struct {
+		input     string
+		seed      uint32
+		expected1 uint64
+		expected2 uint64
+	}{
+		{"", 0, 0, 0},
+		{"hello", 0, 0xcbd8a7b341bd9b02, 0x5b1e906a48ae1d19},
+		{"The quick brown fox jumps over the lazy dog", 0, 0xe34bbc7bbc071b6c, 0x7a433ca9c49a9347},
+	}
+	for _, tt := range murmurTests {
+		h1, h2 := murmur3Sum128([]byte(tt.input), tt.seed)
+		if h1 != tt.expected1 || h2 != tt.expected2 {
+			t.Errorf("murmur3Sum128(%q, %d) = %016x %016x, want %016x %016x", tt.input, tt.seed, h1, h2, tt.expected1, tt.expected2)
+		}
+	}
+
+	// SipHash-2-4-128 with key 00 01 ... 0f over the messages 00, 00 01, ...
+	sipTests := [][16]byte{
+		{0xa3, 0x81, 0x7f, 0x04, 0xba, 0x25, 0xa8, 0xe6, 0x6d, 0xf6, 0x72, 0x14, 0xc7, 0x55, 0x02, 0x93},
+		{0xda, 0x87, 0xc1, 0xd8, 0x6b, 0x99, 0xaf, 0x44, 0x34, 0x76, 0x59, 0x11, 0x9b, 0x22, 0xfc, 0x45},
+	}
+	var key [16]byte
+	message := make([]byte, len(sipTests))
+	for i := range key {
+		key[i] = byte(i)
+	}
+	for i := range message {
+		message[i] = byte(i)
+	}
+	for n, expected := range sipTests {
+		h1, h2 := NewSipHasher(key).Sum128(message[:n])
+		var got [16]byte
+		binary.LittleEndian.PutUint64(got[:8], h1)
+		binary.LittleEndian.PutUint64(got[8:], h2)
+		if got != expected {
+			t.Errorf("SipHash-2-4-128 of %d bytes = %x, want %x", n, got, expected)
+		}
+	}
+}
+
+func builtinHashers() []Hasher {
+	return []Hasher{
+		NewFNV1aHasher(),
+		NewXXHash64Hasher(42),
+		NewMurmur3Hasher(7),
+		NewSipHasher([16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}),
+	}
+}
+
+func TestHasherFalsePositiveRate(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	const (
+		expectedElements  = 10000
+		falsePositiveRate = 0.01
+		probes            = 100000
+	)
+	for _, hasher := range builtinHashers() {
+		t.Run(hasher.Scheme().String(), func(t *testing.T) {
+			size := OptimalSize(expectedElements, falsePositiveRate)
+			bf := NewBloomFilterWithHasher(size, OptimalHashFunctions(size, expectedElements), hasher, logger)
+			for i := 0; i < expectedElements; i++ {
+				bf.Add([]byte(fmt.Sprintf("member-%d", i)))
+			}
+
+			falsePositives := 0
+			for i := 0; i < probes; i++ {
+				if bf.Contains([]byte(fmt.Sprintf("probe-%d", i))) {
+					falsePositives++
+				}
+			}
+			actualFPR := float64(falsePositives) / probes
+			tolerance := 5 * math.Sqrt(falsePositiveRate*(1-falsePositiveRate)/probes)
+			if math.Abs(actualFPR-falsePositiveRate) > tolerance {
+				t.Errorf("Actual false positive rate (%f) differs from target (%f) by more than tolerance (%f)", actualFPR, falsePositiveRate, tolerance)
+			}
+		})
+	}
+}
+
+func TestSaveAndLoadPreservesHasher(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	elements := generateRandomStrings(200, 10)
+	for _, hasher := range builtinHashers() {
+		t.Run(hasher.Scheme().String(), func(t *testing.T) {
+			original := NewBloomFilterWithHasher(2000, 5, hasher, logger)
+			for _, elem := range elements {
+				original.Add([]byte(elem))
+			}
+
+			var buf bytes.Buffer
+			if err := original.Save(&buf); err != nil {
+				t.Fatalf("Failed to save Bloom filter: %v", err)
+			}
+			loaded := &Filter{}
+			if err := loaded.Load(&buf, logger); err != nil {
+				t.Fatalf("Failed to load Bloom filter: %v", err)
+			}
+
+			if loaded.hasher.Scheme() != hasher.Scheme() || loaded.hasher.Seed() != hasher.Seed() {
+				t.Errorf("Loaded hasher %v/%x, want %v/%x", loaded.hasher.Scheme(), loaded.hasher.Seed(), hasher.Scheme(), hasher.Seed())
+			}
+			for _, elem := range elements {
+				if !loaded.Contains([]byte(elem)) {
+					t.Fatalf("Expected loaded filter to contain %s", elem)
+				}
+			}
+			for i := range original.bits {
+				if loaded.bits[i] != original.bits[i] {
+					t.Fatalf("Word %d differs after load", i)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadUnknownHashScheme(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	_, data := savedFilter(t, logger)
+
+	// Rewrite the scheme byte and fix up the trailer so only the scheme is wrong.
+	data[7] = 200
+	end := len(data) - 4
+	binary.LittleEndian.PutUint32(data[end:], crc32Castagnoli(data[:end]))
+
+	err := (&Filter{}).Load(bytes.NewReader(data), logger)
+	if !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Load() error = %v, expectedError %v", err, ErrInvalidFormat)
+	}
+}
+
+// linearLocator places the k positions of an element on consecutive bits
+type linearLocator struct {
+	Hasher
+}
+
+func (linearLocator) Location(h1, h2, i, m uint64) uint64 {
+	return (h1%m + i) % m
+}
+
+func TestLocatorPositions(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	const size, numHashFuncs = 1000, 4
+	hasher := linearLocator{NewFNV1aHasher()}
+	element := []byte("hello")
+	h1, _ := hasher.Sum128(element)
+	want := newBitset(size)
+	for i := uint64(0); i < numHashFuncs; i++ {
+		want.set((h1%size + i) % size)
+	}
+
+	bf := NewBloomFilterWithHasher(size, numHashFuncs, hasher, logger)
+	bf.Add(element)
+	batched := NewBloomFilterWithHasher(size, numHashFuncs, hasher, logger)
+	batched.AddBatch([][]byte{element})
+	for _, tt := range []struct {
+		name string
+		bits bitset
+	}{
+		{"Add", bf.bits},
+		{"AddBatch", batched.bits},
+	} {
+		if !slices.Equal(tt.bits, want) {
+			t.Errorf("%s set bits %x, want %x", tt.name, tt.bits, want)
+		}
+	}
+
+	out := make([]bool, 1)
+	if bf.ContainsBatch([][]byte{element}, out); !out[0] || !bf.Contains(element) {
+		t.Error("Filter does not contain an added element")
+	}
+}
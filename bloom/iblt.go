@@ -0,0 +1,308 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/bits"
+	"slices"
+)
+
+// ErrListIncomplete is returned by IBLT.ListEntries when the table holds more
+// entries than it can decode. The entries listed before peeling stalled are
+// still returned.
+var ErrListIncomplete = errors.New("bloom: IBLT could not be fully decoded")
+
+// ibltCheckSeed seeds the hash that verifies a cell holds a single key. It
+// only has to differ from the hashes that pick the cells.
+const ibltCheckSeed = 0x6a09e667f3bcc909
+
+// IBLT is an invertible Bloom lookup table (Goodrich and Mitzenmacher, 2011),
+// used here for set reconciliation as in Eppstein et al., "What's the
+// Difference?" (2011). Every key is added to one cell in each of k equal
+// subtables. A cell holds the number of keys added to it, the XOR of the keys
+// and the XOR of a check hash of each key.
+//
+// Two replicas each build a table of the same shape from their keys, one
+// sends its table to the other, and Subtract leaves a table of only the keys
+// they do not share: the common keys cancel out whatever their number.
+// ListEntries then recovers that difference by repeatedly removing the key of
+// a pure cell, one that holds exactly one key. Decoding succeeds with high
+// probability while the difference is below the capacity the table was sized
+// for with OptimalIBLTSize, however large the sets themselves are.
+//
+// Keys may be up to keySize bytes long; a cell also stores the XOR of the key
+// lengths, so keys that differ only by trailing zero bytes stay distinct.
+type IBLT struct {
+	counts       []int64
+	keySums      []byte
+	lengthSums   []uint64
+	hashSums     []uint64
+	numCells     uint
+	numHashFuncs uint
+	keySize      uint
+	logger       *slog.Logger
+}
+
+// NewIBLT creates a new invertible Bloom lookup table of at least numCells
+// cells split into numHashFuncs subtables, holding keys of at most keySize
+// bytes. The cell count is rounded up to a multiple of numHashFuncs, which
+// must be between 2 and 8. Size the table for the expected difference with
+// OptimalIBLTSize and OptimalIBLTHashFunctions. A nil logger discards log
+// output.
+func NewIBLT(numCells, numHashFuncs, keySize uint, logger *slog.Logger) (*IBLT, error) {
+	if numHashFuncs < 2 || numHashFuncs > 8 {
+		return nil, fmt.Errorf("%w: hash function count must be between 2 and 8, got %d", ErrInvalidParameter, numHashFuncs)
+	}
+	if numCells == 0 {
+		return nil, fmt.Errorf("%w: cell count must be positive", ErrInvalidParameter)
+	}
+	if keySize == 0 {
+		return nil, fmt.Errorf("%w: key size must be positive", ErrInvalidParameter)
+	}
+	logger = orDiscard(logger)
+	numCells = (numCells + numHashFuncs - 1) / numHashFuncs * numHashFuncs
+	t := &IBLT{
+		counts:       make([]int64, numCells),
+		keySums:      make([]byte, numCells*keySize),
+		lengthSums:   make([]uint64, numCells),
+		hashSums:     make([]uint64, numCells),
+		numCells:     numCells,
+		numHashFuncs: numHashFuncs,
+		keySize:      keySize,
+		logger:       logger,
+	}
+
+	t.logger.Info("Created new IBLT", "numCells", numCells, "numHashFuncs", numHashFuncs, "keySize", keySize)
+	return t, nil
+}
+
+// Insert adds a key to the table. It returns an error wrapping
+// ErrInvalidParameter if the key is longer than the key size.
+func (t *IBLT) Insert(key []byte) error {
+	if err := t.checkKey(key); err != nil {
+		return err
+	}
+	t.update(key, 1)
+	t.logger.Info("Inserted key into IBLT", "key", string(key))
+	return nil
+}
+
+// Delete removes a key from the table. Deleting a key that was never inserted
+// is allowed: ListEntries then reports it as a deleted entry. It returns an
+// error wrapping ErrInvalidParameter if the key is longer than the key size.
+func (t *IBLT) Delete(key []byte) error {
+	if err := t.checkKey(key); err != nil {
+		return err
+	}
+	t.update(key, -1)
+	t.logger.Info("Deleted key from IBLT", "key", string(key))
+	return nil
+}
+
+// Compatible reports whether other has the same shape as the table, so that
+// Subtract can combine them. Otherwise it returns an *IncompatibleError.
+func (t *IBLT) Compatible(other *IBLT) error {
+	switch {
+	case t.numCells != other.numCells:
+		return &IncompatibleError{Field: "cells", Left: t.numCells, Right: other.numCells}
+	case t.numHashFuncs != other.numHashFuncs:
+		return &IncompatibleError{Field: "hashes", Left: t.numHashFuncs, Right: other.numHashFuncs}
+	case t.keySize != other.keySize:
+		return &IncompatibleError{Field: "key size", Left: t.keySize, Right: other.keySize}
+	}
+	return nil
+}
+
+// Subtract removes every key of other from the table, cell by cell. Keys in
+// both cancel out, keys only in the table remain inserted and keys only in
+// other become deleted entries. The tables must be Compatible.
+func (t *IBLT) Subtract(other *IBLT) error {
+	if err := t.Compatible(other); err != nil {
+		return err
+	}
+	for i := range t.counts {
+		t.counts[i] -= other.counts[i]
+		t.lengthSums[i] ^= other.lengthSums[i]
+		t.hashSums[i] ^= other.hashSums[i]
+	}
+	for i := range t.keySums {
+		t.keySums[i] ^= other.keySums[i]
+	}
+	t.logger.Info("Subtracted IBLT")
+	return nil
+}
+
+// ListEntries decodes the keys held by the table without modifying it.
+// Inserted keys are those inserted more often than deleted, deleted keys the
+// reverse; after Subtract they are the keys only in the table and only in the
+// other table. If the table holds too many keys to decode, ListEntries returns
+// the keys it did recover together with ErrListIncomplete.
+func (t *IBLT) ListEntries() (inserted, deleted [][]byte, err error) {
+	work := t.clone()
+	queue := make([]uint, 0, t.numCells)
+	for i := uint(0); i < t.numCells; i++ {
+		if work.pure(i) {
+			queue = append(queue, i)
+		}
+	}
+
+	for len(queue) > 0 {
+		i := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		// Peeling an earlier key may have emptied or polluted the cell.
+		if !work.pure(i) {
+			continue
+		}
+		count := work.counts[i]
+		key := slices.Clone(work.keySums[uint64(i)*uint64(t.keySize):][:work.lengthSums[i]])
+		if count > 0 {
+			inserted = append(inserted, key)
+		} else {
+			deleted = append(deleted, key)
+		}
+		work.update(key, -count)
+		h1, h2 := hashElement(key)
+		for j := uint(0); j < t.numHashFuncs; j++ {
+			if cell := work.cell(h1, h2, j); work.pure(cell) {
+				queue = append(queue, cell)
+			}
+		}
+	}
+
+	for i := uint(0); i < t.numCells; i++ {
+		if !work.empty(i) {
+			t.logger.Warn("IBLT could not be fully decoded", "inserted", len(inserted), "deleted", len(deleted))
+			return inserted, deleted, ErrListIncomplete
+		}
+	}
+	t.logger.Info("Listed IBLT entries", "inserted", len(inserted), "deleted", len(deleted))
+	return inserted, deleted, nil
+}
+
+// Save serializes the IBLT to a writer
+func (t *IBLT) Save(w io.Writer) error {
+	return writeGobFrame(w, kindIBLT, struct {
+		Counts       []int64
+		KeySums      []byte
+		LengthSums   []uint64
+		HashSums     []uint64
+		NumHashFuncs uint
+		KeySize      uint
+	}{
+		Counts:       t.counts,
+		KeySums:      t.keySums,
+		LengthSums:   t.lengthSums,
+		HashSums:     t.hashSums,
+		NumHashFuncs: t.numHashFuncs,
+		KeySize:      t.keySize,
+	})
+}
+
+// Load deserializes the IBLT from a reader
+func (t *IBLT) Load(r io.Reader, logger *slog.Logger) error {
+	var data struct {
+		Counts       []int64
+		KeySums      []byte
+		LengthSums   []uint64
+		HashSums     []uint64
+		NumHashFuncs uint
+		KeySize      uint
+	}
+	if err := readGobFrame(r, kindIBLT, &data); err != nil {
+		return err
+	}
+	numCells := uint(len(data.Counts))
+	if data.NumHashFuncs < 2 || data.NumHashFuncs > 8 || data.KeySize == 0 || numCells == 0 || numCells%data.NumHashFuncs != 0 {
+		return fmt.Errorf("%w: IBLT with %d cells, %d hash functions and %d-byte keys", ErrInvalidFormat, numCells, data.NumHashFuncs, data.KeySize)
+	}
+	if uint(len(data.KeySums)) != numCells*data.KeySize || uint(len(data.LengthSums)) != numCells || uint(len(data.HashSums)) != numCells {
+		return fmt.Errorf("%w: IBLT cell arrays do not match %d cells", ErrInvalidFormat, numCells)
+	}
+
+	t.counts = data.Counts
+	t.keySums = data.KeySums
+	t.lengthSums = data.LengthSums
+	t.hashSums = data.HashSums
+	t.numCells = numCells
+	t.numHashFuncs = data.NumHashFuncs
+	t.keySize = data.KeySize
+	t.logger = orDiscard(logger)
+	return nil
+}
+
+// checkKey rejects keys that do not fit a cell
+func (t *IBLT) checkKey(key []byte) error {
+	if uint(len(key)) > t.keySize {
+		return fmt.Errorf("%w: key of %d bytes exceeds the key size %d", ErrInvalidParameter, len(key), t.keySize)
+	}
+	return nil
+}
+
+// update adds delta copies of key to each of its cells
+func (t *IBLT) update(key []byte, delta int64) {
+	h1, h2 := hashElement(key)
+	check := xxhash64(key, ibltCheckSeed)
+	for j := uint(0); j < t.numHashFuncs; j++ {
+		i := t.cell(h1, h2, j)
+		t.counts[i] += delta
+		t.lengthSums[i] ^= uint64(len(key))
+		t.hashSums[i] ^= check
+		sum := t.keySums[uint64(i)*uint64(t.keySize):]
+		for b, c := range key {
+			sum[b] ^= c
+		}
+	}
+}
+
+// cell returns the cell in subtable j of the key with digest h1, h2.
+//
+// Plain double hashing would make the k cells of a key a function of h1 and h2
+// modulo the subtable size, so two keys agreeing there would share all their
+// cells and could never be peeled; with d keys that happens with probability
+// about d²k²/2m². Remixing each position makes the cells independent.
+func (t *IBLT) cell(h1, h2 uint64, j uint) uint {
+	sub := uint64(t.numCells / t.numHashFuncs)
+	offset, _ := bits.Mul64(fmix64(h1+uint64(j)*h2), sub)
+	return j*uint(sub) + uint(offset)
+}
+
+// pure reports whether cell i holds exactly one key, inserted or deleted:
+// its count is ±1 and its key sums match the check hash
+func (t *IBLT) pure(i uint) bool {
+	if t.counts[i] != 1 && t.counts[i] != -1 || t.lengthSums[i] > uint64(t.keySize) {
+		return false
+	}
+	sum := t.keySums[uint64(i)*uint64(t.keySize):][:t.keySize]
+	key, padding := sum[:t.lengthSums[i]], sum[t.lengthSums[i]:]
+	for _, c := range padding {
+		if c != 0 {
+			return false
+		}
+	}
+	return xxhash64(key, ibltCheckSeed) == t.hashSums[i]
+}
+
+// empty reports whether every field of cell i is zero
+func (t *IBLT) empty(i uint) bool {
+	if t.counts[i] != 0 || t.lengthSums[i] != 0 || t.hashSums[i] != 0 {
+		return false
+	}
+	for _, c := range t.keySums[uint64(i)*uint64(t.keySize):][:t.keySize] {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// clone returns an independent copy of the table
+func (t *IBLT) clone() *IBLT {
+	c := *t
+	c.counts = slices.Clone(t.counts)
+	c.keySums = slices.Clone(t.keySums)
+	c.lengthSums = slices.Clone(t.lengthSums)
+	c.hashSums = slices.Clone(t.hashSums)
+	return &c
+}
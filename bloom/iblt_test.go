@@ -0,0 +1,357 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"testing"
+)
+
+// sortedKeys returns the keys as sorted strings for comparison
+func sortedKeys(keys [][]byte) []string {
+	s := make([]string, len(keys))
+	for i, k := range keys {
+		s[i] = string(k)
+	}
+	slices.Sort(s)
+	return s
+}
+
+func TestIBLTReconcile(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	tests := []struct {
+		name       string
+		shared     int
+		onlyLeft   int
+		onlyRight  int
+		difference int
+	}{
+		{"Identical sets", 1000, 0, 0, 10},
+		{"Only left has extra keys", 1000, 7, 0, 10},
+		{"Only right has extra keys", 1000, 0, 7, 10},
+		{"Both sides differ", 5000, 25, 25, 50},
+		{"Difference far smaller than the sets", 100000, 60, 40, 100},
+		{"Large difference", 2000, 800, 700, 1500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cells, numHashFuncs := OptimalIBLTSize(tt.difference), OptimalIBLTHashFunctions(tt.difference)
+			left, err := NewIBLT(cells, numHashFuncs, 32, logger)
+			if err != nil {
+				t.Fatalf("NewIBLT() error = %v", err)
+			}
+			right, err := NewIBLT(cells, numHashFuncs, 32, logger)
+			if err != nil {
+				t.Fatalf("NewIBLT() error = %v", err)
+			}
+
+			for i := 0; i < tt.shared; i++ {
+				key := []byte(fmt.Sprintf("shared-%d", i))
+				if err := left.Insert(key); err != nil {
+					t.Fatalf("Insert() error = %v", err)
+				}
+				if err := right.Insert(key); err != nil {
+					t.Fatalf("Insert() error = %v", err)
+				}
+			}
+			var wantLeft, wantRight []string
+			for i := 0; i < tt.onlyLeft; i++ {
+				key := fmt.Sprintf("left-%d", i)
+				wantLeft = append(wantLeft, key)
+				if err := left.Insert([]byte(key)); err != nil {
+					t.Fatalf("Insert() error = %v", err)
+				}
+			}
+			for i := 0; i < tt.onlyRight; i++ {
+				key := fmt.Sprintf("right-%d", i)
+				wantRight = append(wantRight, key)
+				if err := right.Insert([]byte(key)); err != nil {
+					t.Fatalf("Insert() error = %v", err)
+				}
+			}
+			slices.Sort(wantLeft)
+			slices.Sort(wantRight)
+
+			if err := left.Subtract(right); err != nil {
+				t.Fatalf("Subtract() error = %v", err)
+			}
+			inserted, deleted, err := left.ListEntries()
+			if err != nil {
+				t.Fatalf("ListEntries() error = %v", err)
+			}
+			if got := sortedKeys(inserted); !slices.Equal(got, wantLeft) {
+				t.Errorf("ListEntries() inserted = %v, want %v", got, wantLeft)
+			}
+			if got := sortedKeys(deleted); !slices.Equal(got, wantRight) {
+				t.Errorf("ListEntries() deleted = %v, want %v", got, wantRight)
+			}
+
+			// Listing does not consume the table.
+			again, _, err := left.ListEntries()
+			if err != nil || len(again) != len(inserted) {
+				t.Errorf("Second ListEntries() listed %d keys with error %v, want %d", len(again), err, len(inserted))
+			}
+		})
+	}
+}
+
+func TestIBLTKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		inserted []string
+		deleted  []string
+	}{
+		{"Empty table", nil, nil},
+		{"Empty key", []string{""}, nil},
+		{"Trailing zero bytes", []string{"ab", "ab\x00", "ab\x00\x00"}, nil},
+		{"Full-size key", []string{"0123456789abcdef"}, nil},
+		{"Deleted without insert", nil, []string{"ghost"}},
+		{"Inserted and deleted", []string{"a", "bb"}, []string{"ccc", "dddd"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := NewIBLT(OptimalIBLTSize(10), OptimalIBLTHashFunctions(10), 16, nil)
+			if err != nil {
+				t.Fatalf("NewIBLT() error = %v", err)
+			}
+			for _, key := range tt.inserted {
+				if err := table.Insert([]byte(key)); err != nil {
+					t.Fatalf("Insert(%q) error = %v", key, err)
+				}
+			}
+			for _, key := range tt.deleted {
+				if err := table.Delete([]byte(key)); err != nil {
+					t.Fatalf("Delete(%q) error = %v", key, err)
+				}
+			}
+
+			inserted, deleted, err := table.ListEntries()
+			if err != nil {
+				t.Fatalf("ListEntries() error = %v", err)
+			}
+			wantInserted, wantDeleted := slices.Clone(tt.inserted), slices.Clone(tt.deleted)
+			slices.Sort(wantInserted)
+			slices.Sort(wantDeleted)
+			if got := sortedKeys(inserted); !slices.Equal(got, wantInserted) {
+				t.Errorf("ListEntries() inserted = %q, want %q", got, wantInserted)
+			}
+			if got := sortedKeys(deleted); !slices.Equal(got, wantDeleted) {
+				t.Errorf("ListEntries() deleted = %q, want %q", got, wantDeleted)
+			}
+		})
+	}
+}
+
+func TestIBLTInsertDeleteCancel(t *testing.T) {
+	table, err := NewIBLT(60, 3, 8, nil)
+	if err != nil {
+		t.Fatalf("NewIBLT() error = %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := table.Insert([]byte(fmt.Sprintf("key-%d", i))); err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if err := table.Delete([]byte(fmt.Sprintf("key-%d", i))); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+	}
+	for i := uint(0); i < table.numCells; i++ {
+		if !table.empty(i) {
+			t.Fatalf("Expected cell %d to be empty after deleting every inserted key", i)
+		}
+	}
+}
+
+func TestIBLTListIncomplete(t *testing.T) {
+	// Far more keys than cells: peeling stalls almost at once.
+	table, err := NewIBLT(OptimalIBLTSize(10), OptimalIBLTHashFunctions(10), 16, nil)
+	if err != nil {
+		t.Fatalf("NewIBLT() error = %v", err)
+	}
+	const n = 500
+	for i := 0; i < n; i++ {
+		if err := table.Insert([]byte(fmt.Sprintf("key-%d", i))); err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+	}
+	inserted, deleted, err := table.ListEntries()
+	if !errors.Is(err, ErrListIncomplete) {
+		t.Fatalf("ListEntries() error = %v, expectedError %v", err, ErrListIncomplete)
+	}
+	if len(inserted) >= n || len(deleted) != 0 {
+		t.Errorf("ListEntries() listed %d inserted and %d deleted keys, want a partial list of inserted keys", len(inserted), len(deleted))
+	}
+	for _, key := range inserted {
+		var i int
+		if _, err := fmt.Sscanf(string(key), "key-%d", &i); err != nil || i < 0 || i >= n {
+			t.Errorf("ListEntries() listed %q, which was never inserted", key)
+		}
+	}
+}
+
+func TestOptimalIBLTSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		difference int
+		trials     int
+	}{
+		{"Single key", 1, 200},
+		{"Small difference", 10, 200},
+		{"Medium difference", 200, 50},
+		{"Difference at the hash function switch", 1000, 10},
+		{"Large difference", 5000, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cells, numHashFuncs := OptimalIBLTSize(tt.difference), OptimalIBLTHashFunctions(tt.difference)
+			if cells < uint(tt.difference) {
+				t.Fatalf("OptimalIBLTSize(%d) = %d, want at least one cell per key", tt.difference, cells)
+			}
+			// Decoding fails less than 1% of the time; allow twice that, and at
+			// least one failure, for sampling noise.
+			failures := 0
+			for trial := 0; trial < tt.trials; trial++ {
+				table, err := NewIBLT(cells, numHashFuncs, 16, nil)
+				if err != nil {
+					t.Fatalf("NewIBLT() error = %v", err)
+				}
+				for i := 0; i < tt.difference; i++ {
+					if err := table.Insert([]byte(fmt.Sprintf("trial-%d-%d", trial, i))); err != nil {
+						t.Fatalf("Insert() error = %v", err)
+					}
+				}
+				if _, _, err := table.ListEntries(); err != nil {
+					failures++
+				}
+			}
+			t.Logf("Difference: %d, cells: %d, hash functions: %d, failures: %d/%d", tt.difference, cells, numHashFuncs, failures, tt.trials)
+			if allowed := max(1, tt.trials/50); failures > allowed {
+				t.Errorf("ListEntries() failed %d of %d times at the expected difference, want at most %d", failures, tt.trials, allowed)
+			}
+		})
+	}
+}
+
+func TestIBLTSubtractIncompatible(t *testing.T) {
+	base, err := NewIBLT(120, 4, 16, nil)
+	if err != nil {
+		t.Fatalf("NewIBLT() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		numCells     uint
+		numHashFuncs uint
+		keySize      uint
+		field        string
+	}{
+		{"Different cell count", 240, 4, 16, "cells"},
+		{"Different hash functions", 120, 3, 16, "hashes"},
+		{"Different key size", 120, 4, 32, "key size"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other, err := NewIBLT(tt.numCells, tt.numHashFuncs, tt.keySize, nil)
+			if err != nil {
+				t.Fatalf("NewIBLT() error = %v", err)
+			}
+			err = base.Subtract(other)
+			var incompatible *IncompatibleError
+			if !errors.As(err, &incompatible) || incompatible.Field != tt.field {
+				t.Errorf("Subtract() error = %v, expected an IncompatibleError on %s", err, tt.field)
+			}
+		})
+	}
+}
+
+func TestIBLTInvalid(t *testing.T) {
+	tests := []struct {
+		name         string
+		numCells     uint
+		numHashFuncs uint
+		keySize      uint
+	}{
+		{"Zero cells", 0, 3, 16},
+		{"One hash function", 100, 1, 16},
+		{"Too many hash functions", 100, 9, 16},
+		{"Zero key size", 100, 3, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := NewIBLT(tt.numCells, tt.numHashFuncs, tt.keySize, nil)
+			if table != nil || !errors.Is(err, ErrInvalidParameter) {
+				t.Errorf("NewIBLT() error = %v, expectedError %v", err, ErrInvalidParameter)
+			}
+		})
+	}
+
+	table, err := NewIBLT(10, 3, 4, nil)
+	if err != nil {
+		t.Fatalf("NewIBLT() error = %v", err)
+	}
+	if table.numCells != 12 {
+		t.Errorf("NewIBLT() rounded 10 cells to %d, want 12 for 3 hash functions", table.numCells)
+	}
+	if err := table.Insert([]byte("too long")); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Insert() error = %v, expectedError %v", err, ErrInvalidParameter)
+	}
+	if err := table.Delete([]byte("too long")); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Delete() error = %v, expectedError %v", err, ErrInvalidParameter)
+	}
+}
+
+func TestIBLTSaveLoad(t *testing.T) {
+	table, err := NewIBLT(OptimalIBLTSize(20), OptimalIBLTHashFunctions(20), 16, nil)
+	if err != nil {
+		t.Fatalf("NewIBLT() error = %v", err)
+	}
+	for i := 0; i < 15; i++ {
+		if err := table.Insert([]byte(fmt.Sprintf("in-%d", i))); err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if err := table.Delete([]byte(fmt.Sprintf("out-%d", i))); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := table.Save(&buf); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	var loaded IBLT
+	if err := loaded.Load(&buf, slog.New(discardHandler{})); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err := loaded.Compatible(table); err != nil {
+		t.Fatalf("Loaded IBLT is not compatible with the saved one: %v", err)
+	}
+
+	inserted, deleted, err := loaded.ListEntries()
+	if err != nil {
+		t.Fatalf("ListEntries() error = %v", err)
+	}
+	if len(inserted) != 15 || len(deleted) != 5 {
+		t.Errorf("ListEntries() after Load listed %d inserted and %d deleted keys, want 15 and 5", len(inserted), len(deleted))
+	}
+
+	// A loaded table subtracts like the original.
+	if err := loaded.Subtract(table); err != nil {
+		t.Fatalf("Subtract() error = %v", err)
+	}
+	if inserted, deleted, err := loaded.ListEntries(); err != nil || len(inserted)+len(deleted) != 0 {
+		t.Errorf("ListEntries() after subtracting the saved table listed %d keys with error %v, want none", len(inserted)+len(deleted), err)
+	}
+}
@@ -0,0 +1,101 @@
+package bloom
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+var (
+	// ErrKeyRequired is returned when loading a keyed filter that was saved without its key
+	ErrKeyRequired = errors.New("bloom: filter was saved without its key")
+	// ErrKeyMismatch is returned when the key passed to LoadWithKey differs from the key stored in the file
+	ErrKeyMismatch = errors.New("bloom: key does not match the saved filter")
+)
+
+// NewKeyedHasher returns a SipHash-2-4 hasher under a secret 128-bit key.
+//
+// Unkeyed hashes such as FNV are public functions, so an attacker who controls
+// the elements can search offline for inputs that all land on chosen bits and
+// drive up the false positive rate of a public-facing filter. SipHash is a
+// keyed pseudorandom function: without the key, bit positions cannot be
+// predicted. For the same reason, Save leaves the key out of the file unless
+// SaveWithKey is used.
+func NewKeyedHasher(key [16]byte) Hasher {
+	return sipHasher{key: key, secret: true}
+}
+
+// NewKeyedBloomFilter creates a new Bloom filter whose bit positions are derived with a secret key
+func NewKeyedBloomFilter(size uint, numHashFuncs uint, key [16]byte, logger *slog.Logger) *Filter {
+	return NewBloomFilterWithHasher(size, numHashFuncs, NewKeyedHasher(key), logger)
+}
+
+// GenerateKey returns a random 128-bit key from crypto/rand
+func GenerateKey() ([16]byte, error) {
+	var key [16]byte
+	_, err := rand.Read(key[:])
+	return key, err
+}
+
+// SaveWithKey serializes the Bloom filter like Save but also writes the secret
+// key of a keyed filter, so Load can restore it without LoadWithKey. Anyone who
+// can read the file can then predict the filter's bit positions.
+func (bf *Filter) SaveWithKey(w io.Writer) error {
+	return bf.save(w, true)
+}
+
+// LoadWithKey deserializes a keyed Bloom filter that was saved without its key
+func (bf *Filter) LoadWithKey(r io.Reader, key [16]byte, logger *slog.Logger) error {
+	return bf.load(r, &key, logger)
+}
+
+// RotateKey builds a replacement for the filter with the same size and number
+// of hash functions under a new secret key. A Bloom filter cannot be rehashed
+// from its bits, so every element is re-added from source, the source of truth
+// for the filter's contents: source is called with an add function and must
+// call it once per element. The original filter is left untouched, so it can
+// keep serving queries until the replacement is swapped in.
+func (bf *Filter) RotateKey(newKey [16]byte, source func(add func(element []byte)) error) (*Filter, error) {
+	rotated := NewKeyedBloomFilter(bf.size, bf.numHashFuncs, newKey, bf.logger)
+	if err := source(rotated.Add); err != nil {
+		return nil, err
+	}
+	bf.logger.Info("Rotated Bloom filter key", "size", bf.size, "numHashFuncs", bf.numHashFuncs)
+	return rotated, nil
+}
+
+// encodeHasher returns the header fields that identify a hasher, leaving a
+// secret key out unless includeKey is set
+func encodeHasher(hasher Hasher, includeKey bool) (HashScheme, uint8, [16]byte) {
+	sip, ok := hasher.(sipHasher)
+	if !ok || !sip.secret {
+		return hasher.Scheme(), 0, hasher.Seed()
+	}
+	if includeKey {
+		return HashSipHash, flagSecretKey, sip.key
+	}
+	return HashSipHash, flagSecretKey | flagKeyOmitted, [16]byte{}
+}
+
+// decodeHasher rebuilds the hasher described by header fields. key supplies a
+// secret key that was left out of the file and must match one that was not.
+func decodeHasher(scheme HashScheme, flags uint8, seed [16]byte, key *[16]byte) (Hasher, error) {
+	if flags&flagSecretKey == 0 {
+		return hasherForScheme(scheme, seed)
+	}
+	if scheme != HashSipHash {
+		return nil, fmt.Errorf("%w: secret key flag on hash scheme %d", ErrInvalidFormat, scheme)
+	}
+	switch {
+	case flags&flagKeyOmitted == 0 && key != nil && *key != seed:
+		return nil, ErrKeyMismatch
+	case flags&flagKeyOmitted == 0:
+		return NewKeyedHasher(seed), nil
+	case key == nil:
+		return nil, ErrKeyRequired
+	default:
+		return NewKeyedHasher(*key), nil
+	}
+}
@@ -0,0 +1,145 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+)
+
+func TestKeyedFilterSaveOmitsKey(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	bf := NewKeyedBloomFilter(1000, 5, key, logger)
+	bf.Add([]byte("hello"))
+
+	var buf bytes.Buffer
+	if err := bf.Save(&buf); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), key[:]) {
+		t.Fatalf("Save() wrote the secret key")
+	}
+	data := buf.Bytes()
+
+	if err := (&Filter{}).Load(bytes.NewReader(data), logger); !errors.Is(err, ErrKeyRequired) {
+		t.Errorf("Load() error = %v, expectedError %v", err, ErrKeyRequired)
+	}
+
+	loaded := &Filter{}
+	if err := loaded.LoadWithKey(bytes.NewReader(data), key, logger); err != nil {
+		t.Fatalf("LoadWithKey() error = %v", err)
+	}
+	if !loaded.Contains([]byte("hello")) {
+		t.Errorf("Expected filter loaded with key to contain hello")
+	}
+
+	// The loaded filter is still keyed, so saving it again keeps the key out.
+	buf.Reset()
+	if err := loaded.Save(&buf); err != nil {
+		t.Fatalf("Save() of loaded filter error = %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), key[:]) {
+		t.Errorf("Save() of loaded filter wrote the secret key")
+	}
+}
+
+func TestKeyedFilterSaveWithKey(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	key := [16]byte{0: 0xaa, 15: 0x55}
+	bf := NewKeyedBloomFilter(1000, 5, key, logger)
+	bf.Add([]byte("hello"))
+
+	var buf bytes.Buffer
+	if err := bf.SaveWithKey(&buf); err != nil {
+		t.Fatalf("SaveWithKey() error = %v", err)
+	}
+	data := buf.Bytes()
+
+	loaded := &Filter{}
+	if err := loaded.Load(bytes.NewReader(data), logger); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !loaded.Contains([]byte("hello")) {
+		t.Errorf("Expected filter saved with key to contain hello")
+	}
+
+	otherKey := [16]byte{0: 0xbb}
+	if err := (&Filter{}).LoadWithKey(bytes.NewReader(data), otherKey, logger); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("LoadWithKey() error = %v, expectedError %v", err, ErrKeyMismatch)
+	}
+}
+
+func TestKeyedFilterPositionsDependOnKey(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	a := NewKeyedBloomFilter(1<<16, 5, [16]byte{1}, logger)
+	b := NewKeyedBloomFilter(1<<16, 5, [16]byte{2}, logger)
+	for _, elem := range []string{"apple", "banana", "cherry"} {
+		a.Add([]byte(elem))
+		b.Add([]byte(elem))
+	}
+
+	same := true
+	for i := range a.bits {
+		if a.bits[i] != b.bits[i] {
+			same = false
+			break
+		}
+	}
+	if same {
+		t.Errorf("Expected different keys to set different bits")
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	elements := generateRandomStrings(500, 10)
+	source := func(add func(element []byte)) error {
+		for _, elem := range elements {
+			add([]byte(elem))
+		}
+		return nil
+	}
+
+	oldKey := [16]byte{1}
+	bf := NewKeyedBloomFilter(5000, 5, oldKey, logger)
+	if err := source(bf.Add); err != nil {
+		t.Fatalf("source() error = %v", err)
+	}
+	before := append(bitset{}, bf.bits...)
+
+	newKey := [16]byte{2}
+	rotated, err := bf.RotateKey(newKey, source)
+	if err != nil {
+		t.Fatalf("RotateKey() error = %v", err)
+	}
+	if rotated.size != bf.size || rotated.numHashFuncs != bf.numHashFuncs {
+		t.Errorf("Rotated filter has size %d and %d hashes, want %d and %d", rotated.size, rotated.numHashFuncs, bf.size, bf.numHashFuncs)
+	}
+	if rotated.hasher.Seed() != newKey {
+		t.Errorf("Rotated filter does not use the new key")
+	}
+	for _, elem := range elements {
+		if !rotated.Contains([]byte(elem)) {
+			t.Fatalf("Expected rotated filter to contain %s", elem)
+		}
+	}
+	for i := range before {
+		if bf.bits[i] != before[i] {
+			t.Fatalf("RotateKey() modified the original filter")
+		}
+	}
+
+	errSource := errors.New("source unavailable")
+	if _, err := bf.RotateKey(newKey, func(func([]byte)) error { return errSource }); !errors.Is(err, errSource) {
+		t.Errorf("RotateKey() error = %v, expectedError %v", err, errSource)
+	}
+}
@@ -0,0 +1,269 @@
+//go:build linux
+
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"math"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// filterBitsOffset is the offset of the first bitset word in a saved Filter:
+// the frame header followed by the size and hash function count
+const filterBitsOffset = frameHeaderSize + 16
+
+// ErrReadOnly is returned when adding to a MappedFilter that was opened read-only
+var ErrReadOnly = errors.New("bloom: filter is read-only")
+
+// MappedFilter is a Bloom filter served straight from a memory-mapped file in
+// the format written by Filter.Save. Opening one only reads the header, so
+// startup takes constant time regardless of the filter size, and queries are
+// answered from the page cache without copying the bits onto the heap. This
+// makes it suitable for filters larger than RAM.
+//
+// A MappedFilter opened read-write sets bits directly in the mapping. The
+// checksum trailer is only brought up to date by Sync and Close, so a file
+// that was modified but never synced will fail to load with Filter.Load.
+// MappedFilter is not safe for concurrent use when Add is called.
+type MappedFilter struct {
+	file         *os.File
+	data         []byte
+	bits         bitset
+	size         uint
+	numHashFuncs uint
+	hasher       Hasher
+	writable     bool
+	dirty        bool
+	logger       *slog.Logger
+}
+
+// OpenMappedFilter maps a Bloom filter file written by Filter.Save. If writable
+// is true, Add may be used and changes are written back to the file. The
+// checksum is not verified on open because that would read the whole file;
+// call Verify to check it explicitly. A keyed filter saved without its key
+// returns ErrKeyRequired; use OpenMappedFilterWithKey.
+func OpenMappedFilter(filename string, writable bool, logger *slog.Logger) (*MappedFilter, error) {
+	return openMappedFilter(filename, writable, nil, logger)
+}
+
+// OpenMappedFilterWithKey maps a keyed Bloom filter file that was saved
+// without its key, like Filter.LoadWithKey
+func OpenMappedFilterWithKey(filename string, writable bool, key [16]byte, logger *slog.Logger) (*MappedFilter, error) {
+	return openMappedFilter(filename, writable, &key, logger)
+}
+
+// openMappedFilter opens and maps a filter file, supplying key to a keyed
+// filter saved without one
+func openMappedFilter(filename string, writable bool, key *[16]byte, logger *slog.Logger) (*MappedFilter, error) {
+	flag, prot := os.O_RDONLY, syscall.PROT_READ
+	if writable {
+		flag, prot = os.O_RDWR, syscall.PROT_READ|syscall.PROT_WRITE
+	}
+	file, err := os.OpenFile(filename, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	mf, err := mapFilter(file, prot, writable, key, logger)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	mf.logger.Info("Opened memory-mapped Bloom filter", "filename", filename, "size", mf.size, "numHashFuncs", mf.numHashFuncs, "writable", writable)
+	return mf, nil
+}
+
+// CreateMappedFilter creates an empty, writable memory-mapped Bloom filter
+// file with the given size and number of hash functions, replacing any
+// existing file. The file is created sparse, so creation is fast even for
+// very large filters.
+func CreateMappedFilter(filename string, size uint, numHashFuncs uint, logger *slog.Logger) (*MappedFilter, error) {
+	if size == 0 || numHashFuncs == 0 {
+		return nil, fmt.Errorf("%w: size and hash function count must be positive, got %d and %d", ErrInvalidParameter, size, numHashFuncs)
+	}
+	words := (uint64(size) + 63) / 64
+	h := frameHeader{kind: kindFilter, scheme: HashFNV1a, bodyLen: 16 + 8*words}
+	header := h.marshal()
+	header = binary.LittleEndian.AppendUint64(header, uint64(size))
+	header = binary.LittleEndian.AppendUint64(header, uint64(numHashFuncs))
+
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(int64(filterBitsOffset + 8*words + 4)); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if _, err := file.WriteAt(header, 0); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	mf, err := mapFilter(file, syscall.PROT_READ|syscall.PROT_WRITE, true, nil, logger)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	// The trailer is still zero, so make sure Close writes a valid one.
+	mf.dirty = true
+
+	mf.logger.Info("Created memory-mapped Bloom filter", "filename", filename, "size", size, "numHashFuncs", numHashFuncs)
+	return mf, nil
+}
+
+// mapFilter validates the header of an open filter file and maps it
+func mapFilter(file *os.File, prot int, writable bool, key *[16]byte, logger *slog.Logger) (*MappedFilter, error) {
+	if !littleEndian() {
+		return nil, fmt.Errorf("bloom: memory-mapped filters need a little-endian platform")
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < filterBitsOffset+4 {
+		return nil, fmt.Errorf("%w: file is only %d bytes", ErrTruncated, info.Size())
+	}
+	if info.Size() > math.MaxInt {
+		return nil, fmt.Errorf("%w: file of %d bytes cannot be mapped", ErrInvalidFormat, info.Size())
+	}
+
+	header := make([]byte, filterBitsOffset)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return nil, truncated(err)
+	}
+	h, err := unmarshalFrameHeader(header, kindFilter)
+	if err != nil {
+		return nil, err
+	}
+	hasher, err := decodeHasher(h.scheme, h.flags, h.seed, key)
+	if err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint64(header[frameHeaderSize:])
+	numHashFuncs := binary.LittleEndian.Uint64(header[frameHeaderSize+8:])
+	if err := checkFilterParams(size, numHashFuncs); err != nil {
+		return nil, err
+	}
+	words := (size + 63) / 64
+	if h.bodyLen != 16+8*words {
+		return nil, fmt.Errorf("%w: body of %d bytes does not fit %d bits", ErrInvalidFormat, h.bodyLen, size)
+	}
+	if want := int64(frameHeaderSize + h.bodyLen + 4); info.Size() != want {
+		if info.Size() < want {
+			return nil, fmt.Errorf("%w: file is %d bytes, want %d", ErrTruncated, info.Size(), want)
+		}
+		return nil, fmt.Errorf("%w: file is %d bytes, want %d", ErrInvalidFormat, info.Size(), want)
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), prot, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("bloom: mmap: %w", err)
+	}
+
+	var bits bitset
+	if words > 0 {
+		// The mapping is page aligned and the bits start at an 8-byte aligned
+		// offset, so the words can be viewed in place.
+		bits = unsafe.Slice((*uint64)(unsafe.Pointer(&data[filterBitsOffset])), words)
+	}
+	return &MappedFilter{
+		file:         file,
+		data:         data,
+		bits:         bits,
+		size:         uint(size),
+		numHashFuncs: uint(numHashFuncs),
+		hasher:       hasher,
+		writable:     writable,
+		logger:       orDiscard(logger),
+	}, nil
+}
+
+// Add adds an element to the memory-mapped Bloom filter. It returns
+// ErrReadOnly if the filter was not opened for writing.
+func (mf *MappedFilter) Add(element []byte) error {
+	if !mf.writable {
+		return ErrReadOnly
+	}
+	h1, h2 := mf.hasher.Sum128(element)
+	loc := locatorOf(mf.hasher)
+	for i := uint(0); i < mf.numHashFuncs; i++ {
+		mf.bits.set(locate(loc, h1, h2, i, mf.size))
+	}
+	mf.dirty = true
+	mf.logger.Info("Added element to memory-mapped Bloom filter", "element", string(element))
+	return nil
+}
+
+// Contains checks if an element might be in the memory-mapped Bloom filter
+func (mf *MappedFilter) Contains(element []byte) bool {
+	h1, h2 := mf.hasher.Sum128(element)
+	loc := locatorOf(mf.hasher)
+	for i := uint(0); i < mf.numHashFuncs; i++ {
+		if !mf.bits.test(locate(loc, h1, h2, i, mf.size)) {
+			mf.logger.Debug("Element not found in memory-mapped Bloom filter", "element", string(element), "hashFunc", i)
+			return false
+		}
+	}
+	mf.logger.Info("Element possibly in memory-mapped Bloom filter", "element", string(element))
+	return true
+}
+
+// FalsePositiveRate calculates the current false positive rate of the
+// memory-mapped Bloom filter. It reads every page of the file.
+func (mf *MappedFilter) FalsePositiveRate() float64 {
+	probability := float64(mf.bits.count()) / float64(mf.size)
+	return math.Pow(probability, float64(mf.numHashFuncs))
+}
+
+// Verify recomputes the checksum of the whole file and compares it with the
+// trailer. It reads every page of the file.
+func (mf *MappedFilter) Verify() error {
+	end := len(mf.data) - 4
+	stored := binary.LittleEndian.Uint32(mf.data[end:])
+	if computed := crc32.Checksum(mf.data[:end], castagnoli); stored != computed {
+		return fmt.Errorf("%w: stored %08x, computed %08x", ErrChecksumMismatch, stored, computed)
+	}
+	return nil
+}
+
+// Sync updates the checksum trailer and flushes the mapping to disk. It does
+// nothing for a read-only filter or when nothing was added since the last Sync.
+func (mf *MappedFilter) Sync() error {
+	if !mf.writable || !mf.dirty {
+		return nil
+	}
+	end := len(mf.data) - 4
+	binary.LittleEndian.PutUint32(mf.data[end:], crc32.Checksum(mf.data[:end], castagnoli))
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&mf.data[0])), uintptr(len(mf.data)), syscall.MS_SYNC)
+	if errno != 0 {
+		return fmt.Errorf("bloom: msync: %w", errno)
+	}
+	mf.dirty = false
+	return nil
+}
+
+// Close syncs a writable filter, unmaps the file and closes it. The filter
+// must not be used afterwards.
+func (mf *MappedFilter) Close() error {
+	syncErr := mf.Sync()
+	unmapErr := syscall.Munmap(mf.data)
+	closeErr := mf.file.Close()
+	mf.data, mf.bits = nil, nil
+	return errors.Join(syncErr, unmapErr, closeErr)
+}
+
+// littleEndian reports whether the platform stores integers little-endian,
+// which the in-place view of the bitset words relies on
+func littleEndian() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}
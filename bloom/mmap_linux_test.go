@@ -0,0 +1,189 @@
+//go:build linux
+
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMappedFilterReadsSavedFilter(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	filename := filepath.Join(t.TempDir(), "filter.bin")
+
+	size := OptimalSize(5000, 0.01)
+	bf := NewBloomFilter(size, OptimalHashFunctions(size, 5000), logger)
+	for i := 0; i < 5000; i++ {
+		bf.Add([]byte(fmt.Sprintf("member-%d", i)))
+	}
+	if err := SaveFilterToFile(bf, filename, logger); err != nil {
+		t.Fatalf("SaveFilterToFile() error = %v", err)
+	}
+
+	mf, err := OpenMappedFilter(filename, false, logger)
+	if err != nil {
+		t.Fatalf("OpenMappedFilter() error = %v", err)
+	}
+	defer func() {
+		if err := mf.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+	}()
+
+	if err := mf.Verify(); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	for i := 0; i < 10000; i++ {
+		elem := []byte(fmt.Sprintf("member-%d", i))
+		if mf.Contains(elem) != bf.Contains(elem) {
+			t.Fatalf("Mismatch for element %s", elem)
+		}
+	}
+	if mf.FalsePositiveRate() != bf.FalsePositiveRate() {
+		t.Errorf("False positive rates differ: mapped %v, heap %v", mf.FalsePositiveRate(), bf.FalsePositiveRate())
+	}
+	if err := mf.Add([]byte("new")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Add() on read-only filter error = %v, expectedError %v", err, ErrReadOnly)
+	}
+}
+
+func TestMappedFilterReadWrite(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	filename := filepath.Join(t.TempDir(), "filter.bin")
+
+	mf, err := CreateMappedFilter(filename, 10000, 5, logger)
+	if err != nil {
+		t.Fatalf("CreateMappedFilter() error = %v", err)
+	}
+	for _, elem := range []string{"apple", "banana"} {
+		if err := mf.Add([]byte(elem)); err != nil {
+			t.Fatalf("Add(%s) error = %v", elem, err)
+		}
+	}
+	if err := mf.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if err := mf.Verify(); err != nil {
+		t.Errorf("Verify() after Sync error = %v", err)
+	}
+	if err := mf.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Reopen read-write, add more, and let Close sync.
+	mf, err = OpenMappedFilter(filename, true, logger)
+	if err != nil {
+		t.Fatalf("OpenMappedFilter() error = %v", err)
+	}
+	if err := mf.Add([]byte("cherry")); err != nil {
+		t.Fatalf("Add(cherry) error = %v", err)
+	}
+	if err := mf.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// The file is a regular saved filter that loads onto the heap.
+	loaded, err := LoadFilterFromFile(filename, logger)
+	if err != nil {
+		t.Fatalf("LoadFilterFromFile() error = %v", err)
+	}
+	for _, elem := range []string{"apple", "banana", "cherry"} {
+		if !loaded.Contains([]byte(elem)) {
+			t.Errorf("Expected loaded filter to contain %s", elem)
+		}
+	}
+	if loaded.Contains([]byte("durian")) {
+		t.Errorf("Expected loaded filter not to contain durian")
+	}
+}
+
+func TestMappedFilterInvalidFiles(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	dir := t.TempDir()
+
+	_, data := savedFilter(t, logger)
+	write := func(name string, data []byte) string {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, data, 0o644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		return filename
+	}
+
+	tests := []struct {
+		name          string
+		filename      string
+		expectedError error
+	}{
+		{"Missing file", filepath.Join(dir, "missing.bin"), os.ErrNotExist},
+		{"Empty file", write("empty.bin", nil), ErrTruncated},
+		{"Truncated bits", write("truncated.bin", data[:len(data)-12]), ErrTruncated},
+		{"Trailing garbage", write("long.bin", append(append([]byte{}, data...), 0, 0, 0, 0)), ErrInvalidFormat},
+		{"Legacy gob", write("legacy.gob", append([]byte("gob!"), make([]byte, 100)...)), ErrInvalidFormat},
+		{"Zero size", write("zero.bin", filterFrame(t, 0, 3, 0)), ErrInvalidFormat},
+		{"Size whose word count overflows", write("overflow.bin", filterFrame(t, math.MaxUint64, 3, 0)), ErrInvalidFormat},
+		{"Zero hash functions", write("nohash.bin", filterFrame(t, 1000, 0, 16)), ErrInvalidFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := OpenMappedFilter(tt.filename, false, logger)
+			if !errors.Is(err, tt.expectedError) {
+				t.Errorf("OpenMappedFilter() error = %v, expectedError %v", err, tt.expectedError)
+			}
+		})
+	}
+
+	corrupt := append([]byte{}, data...)
+	corrupt[70] ^= 0xff
+	mf, err := OpenMappedFilter(write("corrupt.bin", corrupt), false, logger)
+	if err != nil {
+		t.Fatalf("OpenMappedFilter() error = %v", err)
+	}
+	defer func() {
+		if err := mf.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+	}()
+	if err := mf.Verify(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Verify() error = %v, expectedError %v", err, ErrChecksumMismatch)
+	}
+}
+
+func TestMappedFilterWithKey(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	filename := filepath.Join(t.TempDir(), "keyed.bin")
+
+	key := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	bf := NewKeyedBloomFilter(10000, 5, key, logger)
+	for i := 0; i < 500; i++ {
+		bf.Add([]byte(fmt.Sprintf("member-%d", i)))
+	}
+	if err := SaveFilterToFile(bf, filename, logger); err != nil {
+		t.Fatalf("SaveFilterToFile() error = %v", err)
+	}
+
+	if _, err := OpenMappedFilter(filename, false, logger); !errors.Is(err, ErrKeyRequired) {
+		t.Errorf("OpenMappedFilter() error = %v, expectedError %v", err, ErrKeyRequired)
+	}
+	mf, err := OpenMappedFilterWithKey(filename, false, key, logger)
+	if err != nil {
+		t.Fatalf("OpenMappedFilterWithKey() error = %v", err)
+	}
+	defer func() {
+		if err := mf.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		elem := []byte(fmt.Sprintf("member-%d", i))
+		if mf.Contains(elem) != bf.Contains(elem) {
+			t.Fatalf("Mismatch for element %s", elem)
+		}
+	}
+}
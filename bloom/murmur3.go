@@ -0,0 +1,71 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// MurmurHash3 x64_128 constants
+const (
+	murmurC1 uint64 = 0x87c37b91114253d5
+	murmurC2 uint64 = 0x4cf5ad432745937f
+)
+
+// murmur3Sum128 computes the x64 128-bit variant of MurmurHash3 of b with the
+// given seed, matching MurmurHash3_x64_128 from the reference SMHasher code
+func murmur3Sum128(b []byte, seed uint32) (uint64, uint64) {
+	n := len(b)
+	h1, h2 := uint64(seed), uint64(seed)
+
+	for ; len(b) >= 16; b = b[16:] {
+		k1 := binary.LittleEndian.Uint64(b[0:8])
+		k2 := binary.LittleEndian.Uint64(b[8:16])
+
+		h1 ^= murmurMixK1(k1)
+		h1 = bits.RotateLeft64(h1, 27)
+		h1 += h2
+		h1 = h1*5 + 0x52dce729
+
+		h2 ^= murmurMixK2(k2)
+		h2 = bits.RotateLeft64(h2, 31)
+		h2 += h1
+		h2 = h2*5 + 0x38495ab5
+	}
+
+	// Tail: up to 15 remaining bytes, little-endian into k1 then k2.
+	var k1, k2 uint64
+	for i := len(b) - 1; i >= 8; i-- {
+		k2 = k2<<8 | uint64(b[i])
+	}
+	for i := min(len(b), 8) - 1; i >= 0; i-- {
+		k1 = k1<<8 | uint64(b[i])
+	}
+	if len(b) > 8 {
+		h2 ^= murmurMixK2(k2)
+	}
+	if len(b) > 0 {
+		h1 ^= murmurMixK1(k1)
+	}
+
+	h1 ^= uint64(n)
+	h2 ^= uint64(n)
+	h1 += h2
+	h2 += h1
+	h1 = fmix64(h1)
+	h2 = fmix64(h2)
+	h1 += h2
+	h2 += h1
+	return h1, h2
+}
+
+func murmurMixK1(k uint64) uint64 {
+	k *= murmurC1
+	k = bits.RotateLeft64(k, 31)
+	return k * murmurC2
+}
+
+func murmurMixK2(k uint64) uint64 {
+	k *= murmurC2
+	k = bits.RotateLeft64(k, 33)
+	return k * murmurC1
+}
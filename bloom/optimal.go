@@ -1,8 +1,12 @@
 package bloom
 
-import "math"
+import (
+	"fmt"
+	"math"
+)
 
-// OptimalSize calculates the optimal size of the Bloom filter
+// OptimalSize calculates the optimal size of the Bloom filter. It does not validate its
+// arguments; New rejects non-positive element counts and rates outside (0, 1).
 func OptimalSize(expectedElements int, falsePositiveRate float64) uint {
 	// This formula is the standard and widely accepted optimal size calculation for Bloom filters.
 	// It's derived from probability theory and optimization principles. Here's why it's considered optimal:
@@ -43,3 +47,196 @@ func OptimalHashFunctions(size uint, expectedElements int) uint {
 	numHash := uint(math.Ceil(float64(size) / float64(expectedElements) * math.Log(2)))
 	return numHash
 }
+
+// BlockedFalsePositiveRate models the false positive rate of a BlockedFilter
+// of size bits in blocks of blockBits after expectedElements insertions.
+//
+// OptimalSize assumes every element's bits are spread over the whole array.
+// In a blocked filter the number of elements in a block instead follows a
+// Poisson distribution with mean λ = n/b over the b blocks, and a query for an
+// absent element tests k distinct bits of a single block. The rate is
+//
+//	Σ_j Poisson(j; λ) · h_j
+//
+// where h_j is the probability that k distinct bits of a block holding j
+// elements are all set. h_j is computed exactly from the distribution of the
+// number of set bits in such a block rather than from its mean, which matters
+// for small blocks: their fill varies widely and the fullest ones dominate
+// the rate. The result is noticeably higher than the classic rate for the
+// same size, especially with RegisterBlock.
+func BlockedFalsePositiveRate(size uint, numHashFuncs uint, blockBits uint, expectedElements int) float64 {
+	if expectedElements <= 0 || blockBits == 0 || numHashFuncs > blockBits {
+		return 0
+	}
+	lambda := float64(expectedElements) / math.Ceil(float64(size)/float64(blockBits))
+	return blockedRate(lambda, blockHitRates(blockBits, numHashFuncs, poissonBound(lambda)))
+}
+
+// OptimalBlockedParameters calculates the smallest size, a whole number of
+// blocks, and the number of hash functions for which BlockedFalsePositiveRate
+// stays at or below falsePositiveRate with expectedElements elements. It
+// searches for the size instead of using a closed form, starting from
+// OptimalSize, which no blocked filter can beat. Very low rates may be out of
+// reach of RegisterBlock filters, in which case an error is returned.
+func OptimalBlockedParameters(expectedElements int, falsePositiveRate float64, blockBits uint) (uint, uint, error) {
+	if expectedElements <= 0 {
+		return 0, 0, fmt.Errorf("%w: expected elements must be positive, got %d", ErrInvalidParameter, expectedElements)
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return 0, 0, fmt.Errorf("%w: false positive rate must be in (0, 1), got %v", ErrInvalidParameter, falsePositiveRate)
+	}
+	if blockBits != CacheLineBlock && blockBits != RegisterBlock {
+		return 0, 0, fmt.Errorf("%w: block size must be %d or %d bits, got %d", ErrInvalidParameter, CacheLineBlock, RegisterBlock, blockBits)
+	}
+
+	// The search only grows the filter, so the hit rates computed for the
+	// starting size cover every size tried.
+	start := max((OptimalSize(expectedElements, falsePositiveRate)+blockBits-1)/blockBits, 1)
+	maxElements := poissonBound(float64(expectedElements) / float64(start))
+	hitRates := make([][]float64, min(blockBits, 32)+1)
+	for k := 1; k < len(hitRates); k++ {
+		hitRates[k] = blockHitRates(blockBits, uint(k), maxElements)
+	}
+
+	// bestRate returns the lowest modelled rate over k for a filter of blocks blocks
+	bestRate := func(blocks uint) (float64, uint) {
+		lambda := float64(expectedElements) / float64(blocks)
+		rate, numHashFuncs := math.Inf(1), uint(1)
+		for k := 1; k < len(hitRates); k++ {
+			if r := blockedRate(lambda, hitRates[k]); r < rate {
+				rate, numHashFuncs = r, uint(k)
+			}
+		}
+		return rate, numHashFuncs
+	}
+
+	// Double the number of blocks until the target is met, then bisect.
+	const maxGrowth = 64
+	low, high := start, start
+	for {
+		if rate, _ := bestRate(high); rate <= falsePositiveRate {
+			break
+		}
+		if high >= start*maxGrowth {
+			return 0, 0, fmt.Errorf("%w: false positive rate %v is out of reach with %d-bit blocks", ErrInvalidParameter, falsePositiveRate, blockBits)
+		}
+		low, high = high, high*2
+	}
+	for low < high {
+		mid := low + (high-low)/2
+		if rate, _ := bestRate(mid); rate <= falsePositiveRate {
+			high = mid
+		} else {
+			low = mid + 1
+		}
+	}
+	_, numHashFuncs := bestRate(high)
+	return high * blockBits, numHashFuncs, nil
+}
+
+// OptimalIBLTHashFunctions calculates the number of hash functions, the number
+// of cells each key is added to, for an IBLT that should decode a difference
+// of expectedDifference keys. Four hash functions need the fewest cells for
+// small differences; from about a thousand keys on, three do.
+func OptimalIBLTHashFunctions(expectedDifference int) uint {
+	if expectedDifference < 1000 {
+		return 4
+	}
+	return 3
+}
+
+// OptimalIBLTSize calculates the number of cells of an IBLT with
+// OptimalIBLTHashFunctions hash functions that decodes a difference of
+// expectedDifference keys at least 99% of the time. Like OptimalSize, it does
+// not validate its argument.
+//
+// Peeling a random table with k cells per key succeeds with high probability
+// as long as it has more than c_k cells per key, where c_k ≈ 1.22 for k=3 and
+// 1.30 for k=4 (Molloy, "Cores in random hypergraphs and Boolean formulas",
+// 2005). Below that threshold decoding almost always fails. Tables for small
+// differences also fail when a few keys happen to share all their cells, so
+// the size adds a constant number of cells on top of 1.4 cells per key.
+func OptimalIBLTSize(expectedDifference int) uint {
+	return uint(math.Ceil(1.4*float64(expectedDifference))) + 30
+}
+
+// poissonBound returns the number of elements per block beyond which the
+// Poisson distribution with mean lambda has negligible mass, ten standard
+// deviations above the mean
+func poissonBound(lambda float64) int {
+	return int(math.Ceil(lambda + 10*math.Sqrt(lambda) + 10))
+}
+
+// blockedRate sums the hit rates of blocks holding j elements, weighted by the
+// Poisson probability of a block holding j elements when the mean is lambda
+func blockedRate(lambda float64, hitRates []float64) float64 {
+	rate := 0.0
+	for j := max(0, int(lambda-10*math.Sqrt(lambda)-10)); j < len(hitRates); j++ {
+		lg, _ := math.Lgamma(float64(j) + 1)
+		rate += math.Exp(float64(j)*math.Log(lambda)-lambda-lg) * hitRates[j]
+	}
+	return math.Min(rate, 1)
+}
+
+// blockHitRates returns, for j from 0 to maxElements, the probability that a
+// query hits in a block of blockBits bits holding j elements of k distinct
+// bits each. It tracks the distribution of the number of set bits as elements
+// are added: an element landing on a block with x bits set overlaps them in o
+// bits with hypergeometric probability C(x, o)·C(B-x, k-o)/C(B, k).
+func blockHitRates(blockBits, k uint, maxElements int) []float64 {
+	b, kk := int(blockBits), int(k)
+	logFactorial := make([]float64, b+1)
+	for n := 1; n <= b; n++ {
+		logFactorial[n] = logFactorial[n-1] + math.Log(float64(n))
+	}
+	logChoose := func(n, r int) float64 {
+		return logFactorial[n] - logFactorial[r] - logFactorial[n-r]
+	}
+
+	// overlap[x][o] is the probability that an element overlaps x set bits in o bits
+	hit := make([]float64, b+1)
+	overlap := make([][]float64, b+1)
+	for x := 0; x <= b; x++ {
+		if x >= kk {
+			hit[x] = math.Exp(logChoose(x, kk) - logChoose(b, kk))
+		}
+		overlap[x] = make([]float64, kk+1)
+		for o := max(0, kk-(b-x)); o <= min(kk, x); o++ {
+			overlap[x][o] = math.Exp(logChoose(x, o) + logChoose(b-x, kk-o) - logChoose(b, kk))
+		}
+	}
+
+	dist, next := make([]float64, b+1), make([]float64, b+1)
+	dist[0] = 1
+	rates := make([]float64, maxElements+1)
+	for j := 0; ; j++ {
+		for x, p := range dist {
+			rates[j] += p * hit[x]
+		}
+		if j == maxElements {
+			return rates
+		}
+		clear(next)
+		for x, p := range dist {
+			if p < 1e-300 {
+				continue
+			}
+			for o, q := range overlap[x] {
+				if q > 0 {
+					next[x+kk-o] += p * q
+				}
+			}
+		}
+		dist, next = next, dist
+	}
+}
+
+// blockHitRate returns the probability that k distinct bits drawn from a block
+// of blockBits bits with setBits of them set are all set, C(setBits, k) / C(blockBits, k)
+func blockHitRate(setBits, blockBits, k float64) float64 {
+	rate := 1.0
+	for i := 0.0; i < k; i++ {
+		rate *= math.Max(setBits-i, 0) / (blockBits - i)
+	}
+	return rate
+}
@@ -0,0 +1,175 @@
+package bloom
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+)
+
+// ParameterError describes an invalid or conflicting parameter passed to New.
+// It matches ErrInvalidParameter with errors.Is.
+type ParameterError struct {
+	// Param names the offending parameter, e.g. "capacity" or "fpr"
+	Param string
+	// Value is the rejected value, or nil when a required parameter is missing
+	Value any
+	// Reason explains what is wrong with it
+	Reason string
+}
+
+func (e *ParameterError) Error() string {
+	if e.Value == nil {
+		return fmt.Sprintf("bloom: %s: %s", e.Param, e.Reason)
+	}
+	return fmt.Sprintf("bloom: invalid %s %v: %s", e.Param, e.Value, e.Reason)
+}
+
+// Is reports whether target is ErrInvalidParameter
+func (e *ParameterError) Is(target error) bool {
+	return target == ErrInvalidParameter
+}
+
+// Option configures a Filter built by New
+type Option func(*options)
+
+// options collects the values set by Options; nil means not set
+type options struct {
+	capacity    *int
+	fpr         *float64
+	bits        *uint
+	hashes      *uint
+	hasher      Hasher
+	parallelism *int
+	logger      *slog.Logger
+
+	hasherSet bool
+	loggerSet bool
+}
+
+// WithCapacity sets the number of elements the filter is sized for
+func WithCapacity(expectedElements int) Option {
+	return func(o *options) { o.capacity = &expectedElements }
+}
+
+// WithFPR sets the target false positive rate, in (0, 1), at capacity
+func WithFPR(falsePositiveRate float64) Option {
+	return func(o *options) { o.fpr = &falsePositiveRate }
+}
+
+// WithBits sets the size of the bit array explicitly instead of deriving it from capacity and FPR
+func WithBits(size uint) Option {
+	return func(o *options) { o.bits = &size }
+}
+
+// WithHashes sets the number of hash functions explicitly instead of deriving it from size and capacity
+func WithHashes(numHashFuncs uint) Option {
+	return func(o *options) { o.hashes = &numHashFuncs }
+}
+
+// WithHasher sets the hash strategy; the default is NewFNV1aHasher
+func WithHasher(hasher Hasher) Option {
+	return func(o *options) { o.hasher, o.hasherSet = hasher, true }
+}
+
+// WithParallelism sets how many goroutines AddBatch and ContainsBatch use; the default is 1
+func WithParallelism(n int) Option {
+	return func(o *options) { o.parallelism = &n }
+}
+
+// WithLogger sets the logger; by default nothing is logged
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) { o.logger, o.loggerSet = logger, true }
+}
+
+// New creates a Bloom filter from options, validating every parameter. The
+// size is either given with WithBits or derived from WithCapacity and WithFPR
+// with OptimalSize; the number of hash functions is either given with
+// WithHashes or derived from the size and WithCapacity with
+// OptimalHashFunctions. Invalid or conflicting options return a
+// *ParameterError.
+//
+//	bf, err := bloom.New(bloom.WithCapacity(1_000_000), bloom.WithFPR(0.001))
+func New(opts ...Option) (*Filter, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.capacity != nil && *o.capacity <= 0 {
+		return nil, &ParameterError{Param: "capacity", Value: *o.capacity, Reason: "must be positive"}
+	}
+	if o.fpr != nil && !(*o.fpr > 0 && *o.fpr < 1) {
+		return nil, &ParameterError{Param: "fpr", Value: *o.fpr, Reason: "must be strictly between 0 and 1"}
+	}
+	if o.bits != nil && *o.bits == 0 {
+		return nil, &ParameterError{Param: "bits", Value: *o.bits, Reason: "must be positive"}
+	}
+	if o.hashes != nil && *o.hashes == 0 {
+		return nil, &ParameterError{Param: "hashes", Value: *o.hashes, Reason: "must be positive"}
+	}
+	if o.parallelism != nil && *o.parallelism <= 0 {
+		return nil, &ParameterError{Param: "parallelism", Value: *o.parallelism, Reason: "must be positive"}
+	}
+	if o.hasherSet && o.hasher == nil {
+		return nil, &ParameterError{Param: "hasher", Reason: "must not be nil"}
+	}
+	if o.loggerSet && o.logger == nil {
+		return nil, &ParameterError{Param: "logger", Reason: "must not be nil"}
+	}
+
+	var size uint
+	switch {
+	case o.bits != nil && o.fpr != nil:
+		return nil, &ParameterError{Param: "fpr", Value: *o.fpr, Reason: "cannot be combined with WithBits, which already fixes the size"}
+	case o.bits != nil:
+		size = *o.bits
+	case o.capacity == nil || o.fpr == nil:
+		return nil, &ParameterError{Param: "bits", Reason: "size is unknown; use WithBits or both WithCapacity and WithFPR"}
+	default:
+		optimal := math.Ceil(-float64(*o.capacity) * math.Log(*o.fpr) / math.Pow(math.Log(2), 2))
+		if optimal > float64(math.MaxInt) {
+			return nil, &ParameterError{Param: "capacity", Value: *o.capacity, Reason: fmt.Sprintf("needs %g bits at fpr %v, more than can be addressed", optimal, *o.fpr)}
+		}
+		size = OptimalSize(*o.capacity, *o.fpr)
+	}
+
+	var numHashFuncs uint
+	switch {
+	case o.hashes != nil:
+		numHashFuncs = *o.hashes
+	case o.capacity == nil:
+		return nil, &ParameterError{Param: "hashes", Reason: "number of hash functions is unknown; use WithHashes or WithCapacity"}
+	default:
+		numHashFuncs = max(OptimalHashFunctions(size, *o.capacity), 1)
+	}
+
+	if o.hasher == nil {
+		o.hasher = NewFNV1aHasher()
+	}
+	if o.logger == nil {
+		o.logger = slog.New(discardHandler{})
+	}
+	bf := NewBloomFilterWithHasher(size, numHashFuncs, o.hasher, o.logger)
+	if o.parallelism != nil {
+		bf.SetParallelism(*o.parallelism)
+	}
+	return bf, nil
+}
+
+// discardHandler is a slog.Handler that drops every record
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
+
+// orDiscard returns logger, or one that drops every record if logger is nil.
+// Constructors and Load run the logger they are given through it.
+func orDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.New(discardHandler{})
+	}
+	return logger
+}
@@ -0,0 +1,118 @@
+package bloom
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	tests := []struct {
+		name            string
+		opts            []Option
+		expectedSize    uint
+		expectedNumHash uint
+		expectedScheme  HashScheme
+	}{
+		{
+			name:            "Capacity and FPR",
+			opts:            []Option{WithCapacity(1000), WithFPR(0.01)},
+			expectedSize:    9586,
+			expectedNumHash: 7,
+			expectedScheme:  HashFNV1a,
+		},
+		{
+			name:            "Capacity, FPR and explicit hashes",
+			opts:            []Option{WithCapacity(1000), WithFPR(0.01), WithHashes(3)},
+			expectedSize:    9586,
+			expectedNumHash: 3,
+			expectedScheme:  HashFNV1a,
+		},
+		{
+			name:            "Bits and capacity",
+			opts:            []Option{WithBits(2000), WithCapacity(1000)},
+			expectedSize:    2000,
+			expectedNumHash: 2,
+			expectedScheme:  HashFNV1a,
+		},
+		{
+			name:            "Bits and hashes with hasher and logger",
+			opts:            []Option{WithBits(500), WithHashes(4), WithHasher(NewXXHash64Hasher(1)), WithLogger(logger)},
+			expectedSize:    500,
+			expectedNumHash: 4,
+			expectedScheme:  HashXXHash64,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bf, err := New(tt.opts...)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if bf.size != tt.expectedSize || bf.numHashFuncs != tt.expectedNumHash || bf.hasher.Scheme() != tt.expectedScheme {
+				t.Errorf("New() built size %d, %d hashes, %v; want %d, %d, %v",
+					bf.size, bf.numHashFuncs, bf.hasher.Scheme(), tt.expectedSize, tt.expectedNumHash, tt.expectedScheme)
+			}
+
+			bf.Add([]byte("hello"))
+			if !bf.Contains([]byte("hello")) {
+				t.Errorf("Expected filter built by New to contain hello")
+			}
+		})
+	}
+}
+
+func TestNewInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []Option
+		expectedParam string
+	}{
+		{"No options", nil, "bits"},
+		{"Capacity only", []Option{WithCapacity(100)}, "bits"},
+		{"FPR only", []Option{WithFPR(0.01)}, "bits"},
+		{"Bits only", []Option{WithBits(1000)}, "hashes"},
+		{"Zero capacity", []Option{WithCapacity(0), WithFPR(0.01)}, "capacity"},
+		{"Negative capacity", []Option{WithCapacity(-5), WithFPR(0.01)}, "capacity"},
+		{"Zero FPR", []Option{WithCapacity(100), WithFPR(0)}, "fpr"},
+		{"FPR of one", []Option{WithCapacity(100), WithFPR(1)}, "fpr"},
+		{"Negative FPR", []Option{WithCapacity(100), WithFPR(-0.1)}, "fpr"},
+		{"Zero bits", []Option{WithBits(0), WithHashes(3)}, "bits"},
+		{"Zero hashes", []Option{WithBits(100), WithHashes(0)}, "hashes"},
+		{"Bits and FPR", []Option{WithBits(100), WithFPR(0.01), WithCapacity(10)}, "fpr"},
+		{"Nil hasher", []Option{WithCapacity(100), WithFPR(0.01), WithHasher(nil)}, "hasher"},
+		{"Zero parallelism", []Option{WithCapacity(100), WithFPR(0.01), WithParallelism(0)}, "parallelism"},
+		{"Nil logger", []Option{WithCapacity(100), WithFPR(0.01), WithLogger(nil)}, "logger"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bf, err := New(tt.opts...)
+			if bf != nil {
+				t.Errorf("New() returned a filter for invalid options")
+			}
+			var paramErr *ParameterError
+			if !errors.As(err, &paramErr) {
+				t.Fatalf("New() error = %v, want *ParameterError", err)
+			}
+			if paramErr.Param != tt.expectedParam {
+				t.Errorf("New() rejected %q (%v), want %q", paramErr.Param, err, tt.expectedParam)
+			}
+			if !errors.Is(err, ErrInvalidParameter) {
+				t.Errorf("Expected error to match ErrInvalidParameter")
+			}
+		})
+	}
+}
+
+func TestNewBloomFilterNilLogger(t *testing.T) {
+	bf := NewBloomFilter(100, 3, nil)
+	bf.Add([]byte("hello"))
+	if !bf.Contains([]byte("hello")) {
+		t.Errorf("Expected filter with nil logger to contain hello")
+	}
+}
@@ -0,0 +1,186 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/bits"
+)
+
+// PartitionedFilter is a Bloom filter whose bit array is split into one equal
+// slice per hash function, with hash function i only setting bits in slice i.
+//
+// Every element then sets exactly k distinct bits, one per slice, and a query
+// for an absent element is a false positive exactly when its bit is set in
+// every slice. The false positive rate is the product of the fill ratios of
+// the slices, which makes it simpler to reason about than that of a Filter,
+// where the bits of one element can collide. For the same total size the two
+// have practically the same rate, so OptimalSize and OptimalHashFunctions
+// size it as well:
+//
+//	size := bloom.OptimalSize(n, p)
+//	pf := bloom.NewPartitionedFilter(size, bloom.OptimalHashFunctions(size, n), logger)
+type PartitionedFilter struct {
+	bits         bitset
+	sliceSize    uint
+	numHashFuncs uint
+	hasher       Hasher
+	logger       *slog.Logger
+}
+
+// NewPartitionedFilter creates a new partitioned Bloom filter of at least size
+// bits split into numHashFuncs slices. Each slice is rounded up to a whole
+// number of 64-bit words. Both size and numHashFuncs must be positive. A nil
+// logger discards log output.
+func NewPartitionedFilter(size uint, numHashFuncs uint, logger *slog.Logger) *PartitionedFilter {
+	return NewPartitionedFilterWithHasher(size, numHashFuncs, NewFNV1aHasher(), logger)
+}
+
+// NewPartitionedFilterWithHasher creates a new partitioned Bloom filter that derives bit positions with the given hasher
+func NewPartitionedFilterWithHasher(size uint, numHashFuncs uint, hasher Hasher, logger *slog.Logger) *PartitionedFilter {
+	if hasher == nil {
+		hasher = NewFNV1aHasher()
+	}
+	logger = orDiscard(logger)
+	sliceSize := (size + numHashFuncs - 1) / numHashFuncs
+	sliceSize = (sliceSize + 63) &^ 63
+	pf := &PartitionedFilter{
+		bits:         newBitset(sliceSize * numHashFuncs),
+		sliceSize:    sliceSize,
+		numHashFuncs: numHashFuncs,
+		hasher:       hasher,
+		logger:       logger,
+	}
+
+	pf.logger.Info("Created new partitioned Bloom filter", "size", sliceSize*numHashFuncs, "sliceSize", sliceSize,
+		"numHashFuncs", numHashFuncs, "hasher", hasher.Scheme())
+	return pf
+}
+
+// Add adds an element to the Bloom filter, setting one bit in every slice
+func (pf *PartitionedFilter) Add(element []byte) {
+	h1, h2 := pf.hasher.Sum128(element)
+	for i := uint(0); i < pf.numHashFuncs; i++ {
+		index := pf.index(h1, h2, i)
+		pf.bits.set(index)
+		pf.logger.Debug("Set bit in slice", "hashFunc", i, "index", index)
+	}
+	pf.logger.Info("Added element to partitioned Bloom filter", "element", string(element))
+}
+
+// Contains checks if an element might be in the Bloom filter
+func (pf *PartitionedFilter) Contains(element []byte) bool {
+	h1, h2 := pf.hasher.Sum128(element)
+	for i := uint(0); i < pf.numHashFuncs; i++ {
+		if !pf.bits.test(pf.index(h1, h2, i)) {
+			pf.logger.Debug("Element not found in partitioned Bloom filter", "element", string(element), "hashFunc", i)
+			return false
+		}
+	}
+	pf.logger.Info("Element possibly in partitioned Bloom filter", "element", string(element))
+	return true
+}
+
+// FalsePositiveRate calculates the current false positive rate of the Bloom
+// filter as the product of the fraction of bits set in each slice
+func (pf *PartitionedFilter) FalsePositiveRate() float64 {
+	sliceWords := pf.sliceSize / 64
+	rate := 1.0
+	for i := uint(0); i < pf.numHashFuncs; i++ {
+		setBits := pf.bits[i*sliceWords : (i+1)*sliceWords].count()
+		rate *= float64(setBits) / float64(pf.sliceSize)
+	}
+	return rate
+}
+
+// Size returns the total number of bits, numHashFuncs times the slice size
+func (pf *PartitionedFilter) Size() uint {
+	return pf.sliceSize * pf.numHashFuncs
+}
+
+// Save serializes the Bloom filter to a writer in the framed binary format.
+// The body is the slice size and hash function count as uint64s followed by
+// the words of all slices in order. The key of a keyed filter is not written;
+// see SaveWithKey.
+func (pf *PartitionedFilter) Save(w io.Writer) error {
+	return pf.save(w, false)
+}
+
+// SaveWithKey serializes the Bloom filter like Save but also writes the secret
+// key of a keyed filter, so Load can restore it without LoadWithKey
+func (pf *PartitionedFilter) SaveWithKey(w io.Writer) error {
+	return pf.save(w, true)
+}
+
+// save writes the framed format, including a secret hasher key only if includeKey is set
+func (pf *PartitionedFilter) save(w io.Writer, includeKey bool) error {
+	h := frameHeader{
+		kind:    kindPartitioned,
+		bodyLen: 16 + 8*uint64(len(pf.bits)),
+	}
+	h.scheme, h.flags, h.seed = encodeHasher(pf.hasher, includeKey)
+	return writeFrame(w, h, func(w io.Writer) error {
+		var params [16]byte
+		binary.LittleEndian.PutUint64(params[0:8], uint64(pf.sliceSize))
+		binary.LittleEndian.PutUint64(params[8:16], uint64(pf.numHashFuncs))
+		if _, err := w.Write(params[:]); err != nil {
+			return err
+		}
+		return writeWords(w, pf.bits)
+	})
+}
+
+// Load deserializes the Bloom filter from a reader. A keyed filter saved
+// without its key returns ErrKeyRequired; use LoadWithKey.
+func (pf *PartitionedFilter) Load(r io.Reader, logger *slog.Logger) error {
+	return pf.load(r, nil, logger)
+}
+
+// LoadWithKey deserializes a keyed Bloom filter that was saved without its key
+func (pf *PartitionedFilter) LoadWithKey(r io.Reader, key [16]byte, logger *slog.Logger) error {
+	return pf.load(r, &key, logger)
+}
+
+// load reads the framed format, supplying key to a keyed filter saved without one
+func (pf *PartitionedFilter) load(r io.Reader, key *[16]byte, logger *slog.Logger) error {
+	var sliceSize, numHashFuncs uint64
+	var loaded bitset
+	var hasher Hasher
+	err := readFrame(r, kindPartitioned, func(h frameHeader, r io.Reader) error {
+		var err error
+		if hasher, err = decodeHasher(h.scheme, h.flags, h.seed, key); err != nil {
+			return err
+		}
+		var params [16]byte
+		if _, err := io.ReadFull(r, params[:]); err != nil {
+			return err
+		}
+		sliceSize = binary.LittleEndian.Uint64(params[0:8])
+		numHashFuncs = binary.LittleEndian.Uint64(params[8:16])
+		if sliceSize == 0 || sliceSize%64 != 0 || numHashFuncs == 0 {
+			return fmt.Errorf("%w: slice size %d with %d hash functions", ErrInvalidFormat, sliceSize, numHashFuncs)
+		}
+		if hi, words := bits.Mul64(sliceSize/64, numHashFuncs); hi != 0 || words > math.MaxInt/8 || h.bodyLen != 16+8*words {
+			return fmt.Errorf("%w: body of %d bytes does not fit %d slices of %d bits", ErrInvalidFormat, h.bodyLen, numHashFuncs, sliceSize)
+		}
+		loaded, err = readBitset(r, sliceSize/64*numHashFuncs)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	pf.bits = loaded
+	pf.sliceSize = uint(sliceSize)
+	pf.numHashFuncs = uint(numHashFuncs)
+	pf.hasher = hasher
+	pf.logger = orDiscard(logger)
+	return nil
+}
+
+// index returns the position of the bit hash function i sets, inside slice i
+func (pf *PartitionedFilter) index(h1, h2 uint64, i uint) uint64 {
+	return uint64(i)*uint64(pf.sliceSize) + location(h1, h2, i, pf.sliceSize)
+}
@@ -0,0 +1,223 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestPartitionedFilterAddContains(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	pf := NewPartitionedFilter(1000, 5, logger)
+
+	elements := []string{"apple", "banana", "cherry"}
+	for _, e := range elements {
+		pf.Add([]byte(e))
+	}
+	for _, e := range elements {
+		if !pf.Contains([]byte(e)) {
+			t.Errorf("Expected partitioned filter to contain %s", e)
+		}
+	}
+	if pf.Contains([]byte("durian")) {
+		t.Errorf("Expected partitioned filter not to contain durian")
+	}
+}
+
+func TestPartitionedFilterSlices(t *testing.T) {
+	tests := []struct {
+		name              string
+		size              uint
+		numHash           uint
+		expectedSliceSize uint
+	}{
+		{"Exact multiple", 640, 5, 128},
+		{"Rounded up to words", 1000, 5, 256},
+		{"Single slice", 100, 1, 128},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pf := NewPartitionedFilter(tt.size, tt.numHash, nil)
+			if pf.sliceSize != tt.expectedSliceSize || pf.Size() != tt.expectedSliceSize*tt.numHash {
+				t.Fatalf("slice size %d, size %d; want %d, %d", pf.sliceSize, pf.Size(), tt.expectedSliceSize, tt.expectedSliceSize*tt.numHash)
+			}
+
+			// Every element sets exactly one bit in every slice.
+			pf.Add([]byte("hello"))
+			sliceWords := pf.sliceSize / 64
+			for i := uint(0); i < pf.numHashFuncs; i++ {
+				if n := pf.bits[i*sliceWords : (i+1)*sliceWords].count(); n != 1 {
+					t.Errorf("slice %d has %d bits set, want 1", i, n)
+				}
+			}
+		})
+	}
+}
+
+func TestPartitionedFilterFalsePositiveRate(t *testing.T) {
+	tests := []struct {
+		name              string
+		expectedElements  int
+		falsePositiveRate float64
+		probes            int
+	}{
+		{"One percent", 10000, 0.01, 200000},
+		{"One in a thousand", 20000, 0.001, 500000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size := OptimalSize(tt.expectedElements, tt.falsePositiveRate)
+			pf := NewPartitionedFilter(size, OptimalHashFunctions(size, tt.expectedElements), nil)
+			for i := 0; i < tt.expectedElements; i++ {
+				pf.Add([]byte(fmt.Sprintf("member-%d", i)))
+			}
+
+			falsePositives := 0
+			for i := 0; i < tt.probes; i++ {
+				if pf.Contains([]byte(fmt.Sprintf("probe-%d", i))) {
+					falsePositives++
+				}
+			}
+
+			// Both the measured rate and FalsePositiveRate should be close to the target.
+			actualFPR := float64(falsePositives) / float64(tt.probes)
+			tolerance := 5 * math.Sqrt(tt.falsePositiveRate*(1-tt.falsePositiveRate)/float64(tt.probes))
+			t.Logf("Target FPR: %f, Actual FPR: %f, Estimated FPR: %f", tt.falsePositiveRate, actualFPR, pf.FalsePositiveRate())
+			if math.Abs(actualFPR-tt.falsePositiveRate) > tolerance {
+				t.Errorf("Actual false positive rate (%f) differs from target (%f) by more than tolerance (%f)", actualFPR, tt.falsePositiveRate, tolerance)
+			}
+			if math.Abs(pf.FalsePositiveRate()-actualFPR) > tolerance {
+				t.Errorf("FalsePositiveRate() = %f, measured %f", pf.FalsePositiveRate(), actualFPR)
+			}
+		})
+	}
+}
+
+func TestPartitionedFilterSaveLoad(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	for _, hasher := range []Hasher{NewFNV1aHasher(), NewXXHash64Hasher(42), NewMurmur3Hasher(7)} {
+		t.Run(hasher.Scheme().String(), func(t *testing.T) {
+			pf := NewPartitionedFilterWithHasher(5000, 4, hasher, logger)
+			for i := 0; i < 300; i++ {
+				pf.Add([]byte(fmt.Sprintf("element-%d", i)))
+			}
+
+			var buf bytes.Buffer
+			if err := pf.Save(&buf); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+			loaded := &PartitionedFilter{}
+			if err := loaded.Load(bytes.NewReader(buf.Bytes()), logger); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if loaded.sliceSize != pf.sliceSize || loaded.numHashFuncs != pf.numHashFuncs || loaded.hasher != pf.hasher {
+				t.Errorf("Loaded parameters differ from saved")
+			}
+			for i := 0; i < 300; i++ {
+				if !loaded.Contains([]byte(fmt.Sprintf("element-%d", i))) {
+					t.Errorf("Expected loaded filter to contain element-%d", i)
+				}
+			}
+			if loaded.FalsePositiveRate() != pf.FalsePositiveRate() {
+				t.Errorf("FalsePositiveRate() = %v after load, want %v", loaded.FalsePositiveRate(), pf.FalsePositiveRate())
+			}
+		})
+	}
+}
+
+func TestPartitionedFilterSaveLoadWithKey(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	key := [16]byte{0: 0xaa, 15: 0x55}
+	pf := NewPartitionedFilterWithHasher(5000, 4, NewKeyedHasher(key), logger)
+	for i := 0; i < 300; i++ {
+		pf.Add([]byte(fmt.Sprintf("element-%d", i)))
+	}
+
+	tests := []struct {
+		name         string
+		save         func(w *bytes.Buffer) error
+		expectedLoad error
+	}{
+		{"Save", func(w *bytes.Buffer) error { return pf.Save(w) }, ErrKeyRequired},
+		{"SaveWithKey", func(w *bytes.Buffer) error { return pf.SaveWithKey(w) }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.save(&buf); err != nil {
+				t.Fatalf("%s() error = %v", tt.name, err)
+			}
+			if keyWritten := bytes.Contains(buf.Bytes(), key[:]); keyWritten != (tt.expectedLoad == nil) {
+				t.Errorf("%s() wrote the key: %v", tt.name, keyWritten)
+			}
+			if err := (&PartitionedFilter{}).Load(bytes.NewReader(buf.Bytes()), logger); !errors.Is(err, tt.expectedLoad) {
+				t.Errorf("Load() error = %v, expectedError %v", err, tt.expectedLoad)
+			}
+
+			loaded := &PartitionedFilter{}
+			if err := loaded.LoadWithKey(bytes.NewReader(buf.Bytes()), key, logger); err != nil {
+				t.Fatalf("LoadWithKey() error = %v", err)
+			}
+			for i := 0; i < 300; i++ {
+				if !loaded.Contains([]byte(fmt.Sprintf("element-%d", i))) {
+					t.Fatalf("Expected loaded filter to contain element-%d", i)
+				}
+			}
+			if loaded.FalsePositiveRate() != pf.FalsePositiveRate() {
+				t.Errorf("FalsePositiveRate() = %v after load, want %v", loaded.FalsePositiveRate(), pf.FalsePositiveRate())
+			}
+		})
+	}
+}
+
+func TestPartitionedFilterLoadErrors(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	var buf bytes.Buffer
+	if err := NewPartitionedFilter(1000, 3, logger).Save(&buf); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data := buf.Bytes()
+
+	var plain bytes.Buffer
+	if err := NewBloomFilter(1000, 3, logger).Save(&plain); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// A header for 2^44 words of slices followed by none of them
+	var params [16]byte
+	binary.LittleEndian.PutUint64(params[0:8], 1<<40)
+	binary.LittleEndian.PutUint64(params[8:16], 1<<10)
+	huge := truncatedFrame(kindPartitioned, 16+8<<44, params[:])
+
+	tests := []struct {
+		name          string
+		data          []byte
+		expectedError error
+	}{
+		{"Truncated", data[:len(data)-20], ErrTruncated},
+		{"Truncated huge slices", huge, ErrTruncated},
+		{"Plain filter", plain.Bytes(), ErrInvalidFormat},
+		{"Valid", data, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&PartitionedFilter{}).Load(bytes.NewReader(tt.data), logger)
+			if !errors.Is(err, tt.expectedError) {
+				t.Errorf("Load() error = %v, expectedError %v", err, tt.expectedError)
+			}
+		})
+	}
+
+	if err := (&Filter{}).Load(bytes.NewReader(data), logger); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Filter.Load() error = %v, expectedError %v", err, ErrInvalidFormat)
+	}
+}
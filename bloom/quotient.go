@@ -0,0 +1,613 @@
+package bloom
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+)
+
+const (
+	// quotientMaxLoad is the fraction of canonical slots a quotient filter may
+	// fill; clusters, and with them lookups, grow quickly beyond it
+	quotientMaxLoad = 0.95
+	// quotientMaxRemainder keeps a remainder and its metadata in one word
+	quotientMaxRemainder = 64 - qfMetaBits
+
+	// Slot metadata bits, below the remainder
+	qfOccupied     = 1 << 0 // a run for this canonical slot exists
+	qfContinuation = 1 << 1 // the slot continues the run of the slot before it
+	qfShifted      = 1 << 2 // the remainder is not in its canonical slot
+	qfMetaBits     = 3
+	qfMetaMask     = 1<<qfMetaBits - 1
+)
+
+// QuotientFilter is a quotient filter (Bender et al., "Don't Thrash: How to
+// Cache Your Hash on Flash", 2012). Each element is reduced to a p-bit
+// fingerprint whose high q bits, the quotient, select a canonical slot and
+// whose low r bits, the remainder, are stored in it. Remainders with the same
+// quotient form a sorted run, runs are kept in quotient order, and a run is
+// shifted right past its canonical slot when earlier runs overflow into it.
+// Three metadata bits per slot record enough to recover every quotient.
+//
+// Because the filter stores whole fingerprints it supports deletion, it can
+// double its number of slots without the original keys by moving one bit
+// from each remainder to its quotient (Resize), and two filters can be merged
+// in a single linear pass over their fingerprints in sorted order (Merge), as
+// an LSM tree does when it compacts two runs.
+//
+// Runs never wrap around to the start of the table; instead the table has a
+// few overflow slots past the last canonical slot. Adding an element whose
+// fingerprint is already stored stores it again, so it must be deleted as
+// often as it was added.
+type QuotientFilter struct {
+	slots         []uint64
+	numSlots      uint64
+	quotientBits  uint
+	remainderBits uint
+	count         uint
+	logger        *slog.Logger
+}
+
+// NewQuotientFilter creates a new quotient filter with 2^quotientBits
+// canonical slots storing remainderBits-bit remainders. It holds up to 95% of
+// 2^quotientBits elements with a false positive rate of about
+// load·2^-remainderBits. quotientBits must be between 1 and 40, remainderBits
+// between 1 and 61 and the two at most 64 together. A nil logger discards log
+// output.
+func NewQuotientFilter(quotientBits, remainderBits uint, logger *slog.Logger) (*QuotientFilter, error) {
+	if quotientBits < 1 || quotientBits > 40 {
+		return nil, fmt.Errorf("%w: quotient size must be between 1 and 40 bits, got %d", ErrInvalidParameter, quotientBits)
+	}
+	if maxBits := min(quotientMaxRemainder, 64-quotientBits); remainderBits < 1 || remainderBits > maxBits {
+		return nil, fmt.Errorf("%w: remainder size must be between 1 and %d bits, got %d", ErrInvalidParameter, maxBits, remainderBits)
+	}
+	qf := newQuotientFilter(quotientBits, remainderBits, logger)
+
+	qf.logger.Info("Created new quotient filter", "quotientBits", quotientBits, "remainderBits", remainderBits, "slots", qf.numSlots)
+	return qf, nil
+}
+
+// newQuotientFilter allocates an empty table for valid parameters
+func newQuotientFilter(quotientBits, remainderBits uint, logger *slog.Logger) *QuotientFilter {
+	numSlots, words := quotientLayout(quotientBits, remainderBits)
+	return &QuotientFilter{
+		slots:         make([]uint64, words),
+		numSlots:      numSlots,
+		quotientBits:  quotientBits,
+		remainderBits: remainderBits,
+		logger:        orDiscard(logger),
+	}
+}
+
+// quotientLayout returns the number of slots of a table and the number of
+// words they are packed into
+func quotientLayout(quotientBits, remainderBits uint) (uint64, uint64) {
+	canonical := uint64(1) << quotientBits
+	// The overflow area follows the counting quotient filter: 10·√(2^q) slots
+	// are far more than the longest run that spills past the end at 95% load.
+	numSlots := canonical + uint64(10*math.Sqrt(float64(canonical)))
+	width := uint64(remainderBits + qfMetaBits)
+	return numSlots, (numSlots*width + 63) / 64
+}
+
+// Add adds an element to the quotient filter. It returns ErrFilterFull,
+// leaving the filter unchanged, once the filter holds Capacity elements.
+func (qf *QuotientFilter) Add(element []byte) error {
+	if err := qf.insert(qf.fingerprint(element)); err != nil {
+		qf.logger.Warn("Quotient filter is full", "element", string(element), "count", qf.count)
+		return err
+	}
+	qf.logger.Info("Added element to quotient filter", "element", string(element))
+	return nil
+}
+
+// Contains checks if an element might be in the quotient filter
+func (qf *QuotientFilter) Contains(element []byte) bool {
+	return qf.contains(qf.fingerprint(element))
+}
+
+// Delete removes one copy of an element from the quotient filter. It returns
+// ErrNotPresent if the element is definitely not in the filter. Deleting an
+// element that was never added may remove a different element that shares
+// its fingerprint.
+func (qf *QuotientFilter) Delete(element []byte) error {
+	if err := qf.delete(qf.fingerprint(element)); err != nil {
+		return err
+	}
+	qf.logger.Info("Deleted element from quotient filter", "element", string(element))
+	return nil
+}
+
+// Count returns the number of fingerprints stored in the quotient filter
+func (qf *QuotientFilter) Count() uint {
+	return qf.count
+}
+
+// Capacity returns the number of elements the filter holds before Add
+// returns ErrFilterFull
+func (qf *QuotientFilter) Capacity() uint {
+	return quotientCapacity(qf.quotientBits)
+}
+
+// LoadFactor returns the fraction of canonical slots in use
+func (qf *QuotientFilter) LoadFactor() float64 {
+	return float64(qf.count) / float64(uint64(1)<<qf.quotientBits)
+}
+
+// QuotientBits returns the number of fingerprint bits that select a slot
+func (qf *QuotientFilter) QuotientBits() uint {
+	return qf.quotientBits
+}
+
+// RemainderBits returns the number of fingerprint bits stored in a slot
+func (qf *QuotientFilter) RemainderBits() uint {
+	return qf.remainderBits
+}
+
+// FalsePositiveRate calculates the current false positive rate of the quotient
+// filter: the probability that an absent element's p-bit fingerprint equals
+// one of the n stored ones, 1 - (1 - 2^-p)^n.
+func (qf *QuotientFilter) FalsePositiveRate() float64 {
+	p := math.Ldexp(1, -int(qf.quotientBits+qf.remainderBits))
+	return -math.Expm1(float64(qf.count) * math.Log1p(-p))
+}
+
+// Resize doubles the number of canonical slots by moving the high bit of every
+// remainder into its quotient. The fingerprints, and so the false positive
+// rate at a given element count, stay the same, but the capacity doubles. It
+// returns an error wrapping ErrInvalidParameter if remainders are only one bit
+// long.
+func (qf *QuotientFilter) Resize() error {
+	if qf.remainderBits < 2 || qf.quotientBits >= 40 {
+		return fmt.Errorf("%w: cannot resize a quotient filter with %d quotient and %d remainder bits",
+			ErrInvalidParameter, qf.quotientBits, qf.remainderBits)
+	}
+	resized := newQuotientFilter(qf.quotientBits+1, qf.remainderBits-1, qf.logger)
+	b := quotientBuilder{qf: resized}
+	it := qf.iterator()
+	for fp, ok := it.next(); ok; fp, ok = it.next() {
+		if err := b.append(fp); err != nil {
+			return err
+		}
+	}
+	qf.logger.Info("Resized quotient filter", "quotientBits", resized.quotientBits, "remainderBits", resized.remainderBits)
+	*qf = *resized
+	return nil
+}
+
+// Merge adds every fingerprint stored in other to the filter. Both filters
+// must use the same fingerprint size, quotient plus remainder bits, or an
+// *IncompatibleError is returned; their quotient sizes may differ. The result
+// is built in one pass over both filters' fingerprints in sorted order, with
+// as many quotient bits as the larger filter, doubled as often as needed to
+// hold the elements of both. Fingerprints stored in both are kept twice.
+func (qf *QuotientFilter) Merge(other *QuotientFilter) error {
+	left, right := qf.quotientBits+qf.remainderBits, other.quotientBits+other.remainderBits
+	if left != right {
+		return &IncompatibleError{Field: "fingerprint bits", Left: left, Right: right}
+	}
+	quotientBits := max(qf.quotientBits, other.quotientBits)
+	for qf.count+other.count > quotientCapacity(quotientBits) {
+		quotientBits++
+	}
+	if quotientBits >= left || quotientBits > 40 {
+		return fmt.Errorf("%w: %d elements do not fit a quotient filter with %d-bit fingerprints",
+			ErrFilterFull, qf.count+other.count, left)
+	}
+
+	merged := newQuotientFilter(quotientBits, left-quotientBits, qf.logger)
+	b := quotientBuilder{qf: merged}
+	x, y := qf.iterator(), other.iterator()
+	fx, okX := x.next()
+	fy, okY := y.next()
+	for okX || okY {
+		var err error
+		if okX && (!okY || fx <= fy) {
+			err = b.append(fx)
+			fx, okX = x.next()
+		} else {
+			err = b.append(fy)
+			fy, okY = y.next()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	qf.logger.Info("Merged quotient filters", "count", merged.count, "quotientBits", merged.quotientBits)
+	*qf = *merged
+	return nil
+}
+
+// Fingerprints returns an iterator over the stored fingerprints in ascending
+// order, each quotient<<RemainderBits | remainder. A fingerprint added twice
+// is yielded twice. The filter must not be modified during iteration.
+//
+// The iterator has the signature of iter.Seq[uint64], so with Go 1.23 it can
+// be ranged over directly.
+func (qf *QuotientFilter) Fingerprints() func(yield func(uint64) bool) {
+	return func(yield func(uint64) bool) {
+		it := qf.iterator()
+		for fp, ok := it.next(); ok; fp, ok = it.next() {
+			if !yield(fp) {
+				return
+			}
+		}
+	}
+}
+
+// Save serializes the quotient filter to a writer
+func (qf *QuotientFilter) Save(w io.Writer) error {
+	return writeGobFrame(w, kindQuotient, struct {
+		Slots         []uint64
+		QuotientBits  uint
+		RemainderBits uint
+		Count         uint
+	}{
+		Slots:         qf.slots,
+		QuotientBits:  qf.quotientBits,
+		RemainderBits: qf.remainderBits,
+		Count:         qf.count,
+	})
+}
+
+// Load deserializes the quotient filter from a reader
+func (qf *QuotientFilter) Load(r io.Reader, logger *slog.Logger) error {
+	var data struct {
+		Slots         []uint64
+		QuotientBits  uint
+		RemainderBits uint
+		Count         uint
+	}
+	if err := readGobFrame(r, kindQuotient, &data); err != nil {
+		return err
+	}
+	if data.QuotientBits < 1 || data.QuotientBits > 40 || data.RemainderBits < 1 ||
+		data.RemainderBits > quotientMaxRemainder || data.QuotientBits+data.RemainderBits > 64 {
+		return fmt.Errorf("%w: quotient filter with %d quotient and %d remainder bits", ErrInvalidFormat, data.QuotientBits, data.RemainderBits)
+	}
+	numSlots, words := quotientLayout(data.QuotientBits, data.RemainderBits)
+	if uint64(len(data.Slots)) != words {
+		return fmt.Errorf("%w: quotient filter has %d words, want %d", ErrInvalidFormat, len(data.Slots), words)
+	}
+	loaded := &QuotientFilter{
+		slots:         data.Slots,
+		numSlots:      numSlots,
+		quotientBits:  data.QuotientBits,
+		remainderBits: data.RemainderBits,
+		count:         data.Count,
+		logger:        orDiscard(logger),
+	}
+	if data.Count > loaded.Capacity() {
+		return fmt.Errorf("%w: quotient filter count %d exceeds capacity %d", ErrInvalidFormat, data.Count, loaded.Capacity())
+	}
+	// The lookups walk runs by their metadata bits without bounds checks, so
+	// metadata that does not describe a valid table must not get that far.
+	if err := loaded.validate(); err != nil {
+		return err
+	}
+	*qf = *loaded
+	return nil
+}
+
+// quotientCapacity returns the number of elements a filter with the given
+// quotient size holds
+func quotientCapacity(quotientBits uint) uint {
+	return uint(quotientMaxLoad * float64(uint64(1)<<quotientBits))
+}
+
+// fingerprint returns the p-bit fingerprint of an element
+func (qf *QuotientFilter) fingerprint(element []byte) uint64 {
+	h1, _ := hashElement(element)
+	return h1 >> (64 - qf.quotientBits - qf.remainderBits)
+}
+
+// split divides a fingerprint into its quotient and remainder
+func (qf *QuotientFilter) split(fp uint64) (uint64, uint64) {
+	return fp >> qf.remainderBits, fp & (1<<qf.remainderBits - 1)
+}
+
+// insert stores a fingerprint, shifting the rest of its cluster right by one
+// slot to keep runs sorted
+func (qf *QuotientFilter) insert(fp uint64) error {
+	if qf.count >= qf.Capacity() {
+		return ErrFilterFull
+	}
+	fq, fr := qf.split(fp)
+	if qf.isEmpty(fq) {
+		qf.set(fq, fr<<qfMetaBits|qfOccupied)
+		qf.count++
+		return nil
+	}
+
+	runStart, runEnd := qf.findRun(fq)
+	s := runStart
+	for s < runEnd && qf.get(s)>>qfMetaBits <= fr {
+		s++
+	}
+	end := s
+	for end < qf.numSlots && !qf.isEmpty(end) {
+		end++
+	}
+	if end == qf.numSlots {
+		return ErrFilterFull
+	}
+
+	// Every remainder in [s, end) moves one slot right and is now shifted;
+	// the occupied bits describe canonical slots and stay where they are.
+	for i := end; i > s; i-- {
+		moved := qf.get(i-1)&^qfOccupied | qfShifted
+		qf.set(i, moved|qf.get(i)&qfOccupied)
+	}
+	entry := fr << qfMetaBits
+	if s != runStart {
+		entry |= qfContinuation
+	} else if runEnd > runStart {
+		// The new remainder heads the run, so the old head continues it.
+		qf.set(s+1, qf.get(s+1)|qfContinuation)
+	}
+	if s != fq {
+		entry |= qfShifted
+	}
+	qf.set(s, entry|qf.get(s)&qfOccupied)
+	qf.set(fq, qf.get(fq)|qfOccupied)
+	qf.count++
+	return nil
+}
+
+// contains reports whether a fingerprint is stored
+func (qf *QuotientFilter) contains(fp uint64) bool {
+	fq, fr := qf.split(fp)
+	if qf.get(fq)&qfOccupied == 0 {
+		return false
+	}
+	runStart, runEnd := qf.findRun(fq)
+	for i := runStart; i < runEnd; i++ {
+		if r := qf.get(i) >> qfMetaBits; r >= fr {
+			return r == fr
+		}
+	}
+	return false
+}
+
+// delete removes one copy of a fingerprint, shifting the shifted remainders
+// that follow it left by one slot
+func (qf *QuotientFilter) delete(fp uint64) error {
+	fq, fr := qf.split(fp)
+	if qf.get(fq)&qfOccupied == 0 {
+		return ErrNotPresent
+	}
+	runStart, runEnd := qf.findRun(fq)
+	s := runStart
+	for s < runEnd && qf.get(s)>>qfMetaBits < fr {
+		s++
+	}
+	if s == runEnd || qf.get(s)>>qfMetaBits != fr {
+		return ErrNotPresent
+	}
+
+	// Pull the rest of the cluster left until an empty slot or a remainder in
+	// its canonical slot, which starts the next cluster. Tracking the quotient
+	// of each moved remainder tells when it lands back in its canonical slot.
+	quotient := fq
+	last := s
+	for i := s + 1; i < qf.numSlots; i++ {
+		e := qf.get(i)
+		if e&qfShifted == 0 {
+			break
+		}
+		moved := e &^ qfOccupied
+		if e&qfContinuation == 0 {
+			quotient = qf.nextOccupied(quotient)
+		} else if i == s+1 && s == runStart {
+			// The removed remainder headed its run; the next one takes over.
+			moved &^= qfContinuation
+		}
+		if i-1 == quotient {
+			moved &^= qfShifted
+		}
+		qf.set(i-1, moved|qf.get(i-1)&qfOccupied)
+		last = i
+	}
+	qf.set(last, qf.get(last)&qfOccupied)
+	if runEnd-runStart == 1 {
+		qf.set(fq, qf.get(fq)&^qfOccupied)
+	}
+	qf.count--
+	return nil
+}
+
+// findRun returns the slots [start, end) holding the run of quotient fq. If fq
+// has no run, start == end is where it would begin.
+func (qf *QuotientFilter) findRun(fq uint64) (uint64, uint64) {
+	if qf.isEmpty(fq) {
+		return fq, fq
+	}
+	// Walk back to the start of the cluster, the first remainder in its
+	// canonical slot, then forward one run per occupied slot until the run of
+	// fq. Runs are stored in the order of their quotients.
+	pos := fq
+	for qf.get(pos)&qfShifted != 0 {
+		pos--
+	}
+	quotient := pos
+	for quotient < fq {
+		pos = qf.runEnd(pos)
+		for quotient++; quotient < fq && qf.get(quotient)&qfOccupied == 0; quotient++ {
+		}
+	}
+	if qf.get(fq)&qfOccupied == 0 {
+		return pos, pos
+	}
+	return pos, qf.runEnd(pos)
+}
+
+// runEnd returns the slot after the run starting at pos
+func (qf *QuotientFilter) runEnd(pos uint64) uint64 {
+	for pos++; pos < qf.numSlots && qf.get(pos)&qfContinuation != 0; pos++ {
+	}
+	return pos
+}
+
+// nextOccupied returns the first occupied canonical slot after quotient
+func (qf *QuotientFilter) nextOccupied(quotient uint64) uint64 {
+	for quotient++; qf.get(quotient)&qfOccupied == 0; quotient++ {
+	}
+	return quotient
+}
+
+// validate checks in a single pass that the slots form a table the other
+// methods can walk: every run belongs to an occupied canonical slot at or
+// before it, runs are sorted and in quotient order, the shifted bits match,
+// clusters have no gaps and the count matches the stored remainders
+func (qf *QuotientFilter) validate() error {
+	canonical := uint64(1) << qf.quotientBits
+	// occupied reports whether canonical slot i has a run
+	occupied := func(i uint64) bool {
+		return i < canonical && qf.get(i)&qfOccupied != 0
+	}
+	// next is the first canonical slot not yet matched to a run; runs claim
+	// the occupied slots in order.
+	var next, stored, previous uint64
+	inRun := false
+	for pos := uint64(0); pos < qf.numSlots; pos++ {
+		e := qf.get(pos)
+		if pos >= canonical && e&qfOccupied != 0 {
+			return fmt.Errorf("%w: overflow slot %d is marked occupied", ErrInvalidFormat, pos)
+		}
+		for next <= pos && !occupied(next) {
+			next++
+		}
+		if e&qfMetaMask == 0 {
+			// An empty slot ends its cluster, so every occupied slot before it
+			// must already have its run.
+			if e != 0 || next <= pos {
+				return fmt.Errorf("%w: empty slot %d breaks a cluster", ErrInvalidFormat, pos)
+			}
+			inRun = false
+			continue
+		}
+		remainder := e >> qfMetaBits
+		if e&qfContinuation != 0 {
+			if !inRun || e&qfShifted == 0 || remainder < previous {
+				return fmt.Errorf("%w: slot %d does not continue a sorted run", ErrInvalidFormat, pos)
+			}
+		} else {
+			// A new run belongs to the next unmatched occupied slot, and it is
+			// shifted exactly when that is not its own slot.
+			if next > pos || (e&qfShifted != 0) != (next != pos) {
+				return fmt.Errorf("%w: run at slot %d has no matching occupied slot", ErrInvalidFormat, pos)
+			}
+			next++
+			inRun = true
+		}
+		previous = remainder
+		stored++
+	}
+	for ; next < canonical; next++ {
+		if occupied(next) {
+			return fmt.Errorf("%w: occupied slot %d has no run", ErrInvalidFormat, next)
+		}
+	}
+	if stored != uint64(qf.count) {
+		return fmt.Errorf("%w: quotient filter count %d does not match %d stored remainders", ErrInvalidFormat, qf.count, stored)
+	}
+	return nil
+}
+
+// quotientBuilder fills an empty quotient filter from fingerprints given in
+// ascending order. Each one goes into the first free slot at or after its
+// canonical slot, so the table is built in a single left-to-right pass.
+type quotientBuilder struct {
+	qf   *QuotientFilter
+	next uint64
+}
+
+// append stores a fingerprint no smaller than any appended before it
+func (b *quotientBuilder) append(fp uint64) error {
+	qf := b.qf
+	fq, fr := qf.split(fp)
+	pos := max(fq, b.next)
+	if qf.count >= qf.Capacity() || pos >= qf.numSlots {
+		return ErrFilterFull
+	}
+	entry := fr << qfMetaBits
+	if qf.get(fq)&qfOccupied != 0 {
+		// The previous fingerprint had the same quotient.
+		entry |= qfContinuation
+	}
+	if pos != fq {
+		entry |= qfShifted
+	}
+	qf.set(pos, entry|qf.get(pos)&qfOccupied)
+	qf.set(fq, qf.get(fq)|qfOccupied)
+	qf.count++
+	b.next = pos + 1
+	return nil
+}
+
+// quotientIterator yields the fingerprints of a quotient filter in ascending
+// order by scanning its slots once
+type quotientIterator struct {
+	qf       *QuotientFilter
+	pos      uint64
+	quotient uint64
+}
+
+// iterator returns an iterator positioned before the first fingerprint
+func (qf *QuotientFilter) iterator() *quotientIterator {
+	return &quotientIterator{qf: qf}
+}
+
+// next returns the next fingerprint, or false once all have been returned
+func (it *quotientIterator) next() (uint64, bool) {
+	qf := it.qf
+	for ; it.pos < qf.numSlots; it.pos++ {
+		e := qf.get(it.pos)
+		if e&qfMetaMask == 0 {
+			continue
+		}
+		if e&qfContinuation == 0 {
+			// A new run: in its canonical slot its quotient is the slot,
+			// otherwise it is the next occupied slot after the last run's.
+			if e&qfShifted == 0 {
+				it.quotient = it.pos
+			} else {
+				it.quotient = qf.nextOccupied(it.quotient)
+			}
+		}
+		it.pos++
+		return it.quotient<<qf.remainderBits | e>>qfMetaBits, true
+	}
+	return 0, false
+}
+
+// isEmpty reports whether a slot holds no remainder. A slot that holds one
+// always has a metadata bit set, as does every occupied canonical slot.
+func (qf *QuotientFilter) isEmpty(i uint64) bool {
+	return qf.get(i)&qfMetaMask == 0
+}
+
+// get returns slot i, its remainder above the three metadata bits
+func (qf *QuotientFilter) get(i uint64) uint64 {
+	width := uint64(qf.remainderBits + qfMetaBits)
+	bit := i * width
+	word, offset := bit/64, bit%64
+	v := qf.slots[word] >> offset
+	if offset+width > 64 {
+		v |= qf.slots[word+1] << (64 - offset)
+	}
+	return v & (1<<width - 1)
+}
+
+// set overwrites slot i
+func (qf *QuotientFilter) set(i, v uint64) {
+	width := uint64(qf.remainderBits + qfMetaBits)
+	mask := uint64(1)<<width - 1
+	bit := i * width
+	word, offset := bit/64, bit%64
+	qf.slots[word] = qf.slots[word]&^(mask<<offset) | v<<offset
+	if offset+width > 64 {
+		qf.slots[word+1] = qf.slots[word+1]&^(mask>>(64-offset)) | v>>(64-offset)
+	}
+}
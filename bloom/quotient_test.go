@@ -0,0 +1,487 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"os"
+	"slices"
+	"testing"
+)
+
+// quotientOp adds or deletes the fingerprint quotient<<r | remainder
+type quotientOp struct {
+	del       bool
+	quotient  uint64
+	remainder uint64
+}
+
+func qfAdd(q, r uint64) quotientOp { return quotientOp{quotient: q, remainder: r} }
+func qfDel(q, r uint64) quotientOp { return quotientOp{del: true, quotient: q, remainder: r} }
+
+// checkQuotientFilter verifies that qf stores exactly the fingerprints in want
+// and that its slots match those of a filter built from them in sorted order,
+// whose layout is the only valid one for that multiset
+func checkQuotientFilter(t *testing.T, qf *QuotientFilter, want []uint64) {
+	t.Helper()
+	want = slices.Clone(want)
+	slices.Sort(want)
+
+	var got []uint64
+	qf.Fingerprints()(func(fp uint64) bool {
+		got = append(got, fp)
+		return true
+	})
+	if !slices.Equal(got, want) {
+		t.Fatalf("Fingerprints() = %v, want %v", got, want)
+	}
+	if qf.Count() != uint(len(want)) {
+		t.Errorf("Count() = %d, want %d", qf.Count(), len(want))
+	}
+	for _, fp := range want {
+		if !qf.contains(fp) {
+			t.Errorf("Expected quotient filter to contain fingerprint %#x", fp)
+		}
+	}
+
+	if err := qf.validate(); err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+
+	expected := newQuotientFilter(qf.quotientBits, qf.remainderBits, qf.logger)
+	b := quotientBuilder{qf: expected}
+	for _, fp := range want {
+		if err := b.append(fp); err != nil {
+			t.Fatalf("append(%#x) error = %v", fp, err)
+		}
+	}
+	for i := uint64(0); i < qf.numSlots; i++ {
+		if qf.get(i) != expected.get(i) {
+			t.Fatalf("Slot %d = %#x, want %#x", i, qf.get(i), expected.get(i))
+		}
+	}
+}
+
+func TestQuotientFilterShifting(t *testing.T) {
+	// Three quotient bits give 8 canonical slots and 28 overflow slots, and
+	// four remainder bits allow remainders 0-15.
+	const quotientBits, remainderBits = 3, 4
+
+	tests := []struct {
+		name string
+		ops  []quotientOp
+	}{
+		{"Canonical slots", []quotientOp{qfAdd(1, 5), qfAdd(3, 2), qfAdd(0, 0)}},
+		{"New run head", []quotientOp{qfAdd(2, 9), qfAdd(2, 5), qfAdd(2, 1)}},
+		{"Run middle and tail", []quotientOp{qfAdd(2, 1), qfAdd(2, 9), qfAdd(2, 5)}},
+		{"Duplicate remainders", []quotientOp{qfAdd(2, 5), qfAdd(2, 5), qfAdd(2, 5)}},
+		{"Run pushes next run", []quotientOp{qfAdd(2, 1), qfAdd(3, 1), qfAdd(2, 7)}},
+		{"Run in slot of shifted remainder", []quotientOp{qfAdd(1, 0), qfAdd(1, 1), qfAdd(1, 2), qfAdd(3, 4), qfAdd(2, 3)}},
+		{"Clusters join", []quotientOp{qfAdd(1, 0), qfAdd(1, 1), qfAdd(4, 0), qfAdd(1, 2), qfAdd(1, 3)}},
+		{"Runs spill into overflow", []quotientOp{qfAdd(7, 0), qfAdd(7, 1), qfAdd(7, 2), qfAdd(6, 9), qfAdd(7, 3), qfAdd(6, 8), qfAdd(5, 1)}},
+		{"Delete run head", []quotientOp{qfAdd(2, 1), qfAdd(2, 5), qfAdd(2, 9), qfDel(2, 1)}},
+		{"Delete run tail", []quotientOp{qfAdd(2, 1), qfAdd(2, 5), qfAdd(3, 0), qfDel(2, 5)}},
+		{"Delete middle of run", []quotientOp{qfAdd(2, 1), qfAdd(2, 5), qfAdd(2, 9), qfAdd(3, 0), qfDel(2, 5)}},
+		{"Delete only remainder of run", []quotientOp{qfAdd(2, 1), qfAdd(3, 1), qfAdd(3, 2), qfDel(2, 1)}},
+		{"Delete shifts runs back", []quotientOp{qfAdd(1, 1), qfAdd(1, 2), qfAdd(2, 3), qfAdd(3, 4), qfAdd(4, 5), qfDel(1, 1)}},
+		{"Delete stops at cluster start", []quotientOp{qfAdd(1, 1), qfAdd(1, 2), qfAdd(3, 3), qfAdd(3, 4), qfDel(1, 1)}},
+		{"Delete before shifted run of empty slot", []quotientOp{qfAdd(0, 1), qfAdd(0, 2), qfAdd(0, 3), qfAdd(2, 4), qfDel(0, 2)}},
+		{"Delete one duplicate", []quotientOp{qfAdd(2, 5), qfAdd(2, 5), qfAdd(3, 1), qfDel(2, 5)}},
+		{"Delete from overflow", []quotientOp{qfAdd(6, 0), qfAdd(7, 0), qfAdd(7, 1), qfAdd(7, 2), qfDel(6, 0), qfDel(7, 1)}},
+		{"Delete everything", []quotientOp{qfAdd(1, 1), qfAdd(1, 2), qfAdd(2, 3), qfDel(1, 2), qfDel(2, 3), qfDel(1, 1)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qf, err := NewQuotientFilter(quotientBits, remainderBits, nil)
+			if err != nil {
+				t.Fatalf("NewQuotientFilter() error = %v", err)
+			}
+			var want []uint64
+			for _, op := range tt.ops {
+				fp := op.quotient<<remainderBits | op.remainder
+				if op.del {
+					if err := qf.delete(fp); err != nil {
+						t.Fatalf("delete(%d, %d) error = %v", op.quotient, op.remainder, err)
+					}
+					want = slices.Delete(want, slices.Index(want, fp), slices.Index(want, fp)+1)
+				} else {
+					if err := qf.insert(fp); err != nil {
+						t.Fatalf("insert(%d, %d) error = %v", op.quotient, op.remainder, err)
+					}
+					want = append(want, fp)
+				}
+				checkQuotientFilter(t, qf, want)
+			}
+		})
+	}
+}
+
+func TestQuotientFilterRandomOperations(t *testing.T) {
+	// Six-bit fingerprints in a 16-slot table collide constantly, so random
+	// inserts and deletes reach every shifting case many times over.
+	const quotientBits, remainderBits = 4, 2
+	qf, err := NewQuotientFilter(quotientBits, remainderBits, nil)
+	if err != nil {
+		t.Fatalf("NewQuotientFilter() error = %v", err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	var want []uint64
+	for i := 0; i < 5000; i++ {
+		fp := uint64(rng.Intn(1 << (quotientBits + remainderBits)))
+		if rng.Intn(2) == 0 && len(want) < int(qf.Capacity()) {
+			if err := qf.insert(fp); err != nil {
+				t.Fatalf("insert(%#x) error = %v", fp, err)
+			}
+			want = append(want, fp)
+		} else {
+			err := qf.delete(fp)
+			if j := slices.Index(want, fp); j >= 0 {
+				if err != nil {
+					t.Fatalf("delete(%#x) error = %v", fp, err)
+				}
+				want = slices.Delete(want, j, j+1)
+			} else if !errors.Is(err, ErrNotPresent) {
+				t.Fatalf("delete(%#x) error = %v, expectedError %v", fp, err, ErrNotPresent)
+			}
+		}
+		checkQuotientFilter(t, qf, want)
+	}
+}
+
+func TestQuotientFilter(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	tests := []struct {
+		name          string
+		quotientBits  uint
+		remainderBits uint
+		numElements   int
+	}{
+		{"Half full", 12, 8, 2048},
+		{"Nearly full", 12, 8, 3891},
+		{"Wide remainders", 10, 20, 900},
+		{"Remainders straddling words", 8, 10, 243},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qf, err := NewQuotientFilter(tt.quotientBits, tt.remainderBits, logger)
+			if err != nil {
+				t.Fatalf("NewQuotientFilter() error = %v", err)
+			}
+			for i := 0; i < tt.numElements; i++ {
+				if err := qf.Add([]byte(fmt.Sprintf("member-%d", i))); err != nil {
+					t.Fatalf("Add(member-%d) error = %v at load factor %f", i, err, qf.LoadFactor())
+				}
+			}
+			for i := 0; i < tt.numElements; i++ {
+				if !qf.Contains([]byte(fmt.Sprintf("member-%d", i))) {
+					t.Fatalf("Expected Contains(member-%d) to be true", i)
+				}
+			}
+
+			falsePositives := 0
+			const probes = 100000
+			for i := 0; i < probes; i++ {
+				if qf.Contains([]byte(fmt.Sprintf("probe-%d", i))) {
+					falsePositives++
+				}
+			}
+			actualFPR := float64(falsePositives) / probes
+			estimated := qf.FalsePositiveRate()
+			t.Logf("Load factor: %f, Actual FPR: %f, Estimated FPR: %f", qf.LoadFactor(), actualFPR, estimated)
+			tolerance := 5 * math.Sqrt(estimated*(1-estimated)/probes)
+			if math.Abs(actualFPR-estimated) > tolerance {
+				t.Errorf("Actual false positive rate %f differs from estimate %f by more than %f", actualFPR, estimated, tolerance)
+			}
+
+			// Deleting every other element keeps the rest.
+			for i := 0; i < tt.numElements; i += 2 {
+				if err := qf.Delete([]byte(fmt.Sprintf("member-%d", i))); err != nil {
+					t.Fatalf("Delete(member-%d) error = %v", i, err)
+				}
+			}
+			for i := 1; i < tt.numElements; i += 2 {
+				if !qf.Contains([]byte(fmt.Sprintf("member-%d", i))) {
+					t.Fatalf("Expected Contains(member-%d) to be true after deletes", i)
+				}
+			}
+			if want := uint(tt.numElements / 2); qf.Count() != want {
+				t.Errorf("Count() = %d, want %d", qf.Count(), want)
+			}
+		})
+	}
+}
+
+func TestQuotientFilterFull(t *testing.T) {
+	qf, err := NewQuotientFilter(4, 8, nil)
+	if err != nil {
+		t.Fatalf("NewQuotientFilter() error = %v", err)
+	}
+	for i := uint(0); i < qf.Capacity(); i++ {
+		if err := qf.Add([]byte(fmt.Sprintf("member-%d", i))); err != nil {
+			t.Fatalf("Add(member-%d) error = %v", i, err)
+		}
+	}
+	if err := qf.Add([]byte("one-too-many")); !errors.Is(err, ErrFilterFull) {
+		t.Errorf("Add() error = %v, expectedError %v", err, ErrFilterFull)
+	}
+	if qf.Count() != qf.Capacity() {
+		t.Errorf("Count() = %d, want %d", qf.Count(), qf.Capacity())
+	}
+	if err := qf.Delete([]byte("never-added")); !errors.Is(err, ErrNotPresent) {
+		t.Errorf("Delete() error = %v, expectedError %v", err, ErrNotPresent)
+	}
+}
+
+func TestNewQuotientFilterInvalid(t *testing.T) {
+	tests := []struct {
+		name          string
+		quotientBits  uint
+		remainderBits uint
+	}{
+		{"Zero quotient bits", 0, 8},
+		{"Too many quotient bits", 41, 8},
+		{"Zero remainder bits", 10, 0},
+		{"Remainder wider than a slot", 1, 62},
+		{"Fingerprint wider than 64 bits", 10, 55},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qf, err := NewQuotientFilter(tt.quotientBits, tt.remainderBits, nil)
+			if qf != nil || !errors.Is(err, ErrInvalidParameter) {
+				t.Errorf("NewQuotientFilter() error = %v, expectedError %v", err, ErrInvalidParameter)
+			}
+		})
+	}
+}
+
+func TestQuotientFilterResize(t *testing.T) {
+	qf, err := NewQuotientFilter(8, 12, nil)
+	if err != nil {
+		t.Fatalf("NewQuotientFilter() error = %v", err)
+	}
+	for i := uint(0); i < qf.Capacity(); i++ {
+		if err := qf.Add([]byte(fmt.Sprintf("member-%d", i))); err != nil {
+			t.Fatalf("Add(member-%d) error = %v", i, err)
+		}
+	}
+	var before []uint64
+	qf.Fingerprints()(func(fp uint64) bool {
+		before = append(before, fp)
+		return true
+	})
+	fpr := qf.FalsePositiveRate()
+
+	if err := qf.Resize(); err != nil {
+		t.Fatalf("Resize() error = %v", err)
+	}
+	if qf.QuotientBits() != 9 || qf.RemainderBits() != 11 {
+		t.Errorf("Resize() gave %d quotient and %d remainder bits, want 9 and 11", qf.QuotientBits(), qf.RemainderBits())
+	}
+	checkQuotientFilter(t, qf, before)
+	if qf.FalsePositiveRate() != fpr {
+		t.Errorf("FalsePositiveRate() = %f after Resize, want %f", qf.FalsePositiveRate(), fpr)
+	}
+
+	// The doubled filter takes elements past the old capacity.
+	for i := uint(len(before)); i < qf.Capacity(); i++ {
+		if err := qf.Add([]byte(fmt.Sprintf("member-%d", i))); err != nil {
+			t.Fatalf("Add(member-%d) error = %v after Resize", i, err)
+		}
+	}
+	for i := uint(0); i < qf.Capacity(); i++ {
+		if !qf.Contains([]byte(fmt.Sprintf("member-%d", i))) {
+			t.Fatalf("Expected Contains(member-%d) to be true", i)
+		}
+	}
+
+	narrow, err := NewQuotientFilter(8, 1, nil)
+	if err != nil {
+		t.Fatalf("NewQuotientFilter() error = %v", err)
+	}
+	if err := narrow.Resize(); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Resize() error = %v, expectedError %v", err, ErrInvalidParameter)
+	}
+}
+
+func TestQuotientFilterMerge(t *testing.T) {
+	tests := []struct {
+		name          string
+		leftBits      uint
+		rightBits     uint
+		leftElements  int
+		rightElements int
+		expectedBits  uint
+	}{
+		{"Same size", 10, 10, 300, 300, 10},
+		{"Smaller into larger", 10, 8, 200, 100, 10},
+		{"Larger into smaller", 8, 10, 100, 200, 10},
+		{"Grows to fit both", 8, 8, 200, 200, 9},
+		{"Empty other", 8, 8, 100, 0, 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Both filters use 24-bit fingerprints.
+			left, err := NewQuotientFilter(tt.leftBits, 24-tt.leftBits, nil)
+			if err != nil {
+				t.Fatalf("NewQuotientFilter() error = %v", err)
+			}
+			right, err := NewQuotientFilter(tt.rightBits, 24-tt.rightBits, nil)
+			if err != nil {
+				t.Fatalf("NewQuotientFilter() error = %v", err)
+			}
+			var want []uint64
+			for i := 0; i < tt.leftElements; i++ {
+				key := []byte(fmt.Sprintf("left-%d", i))
+				if err := left.Add(key); err != nil {
+					t.Fatalf("Add(%s) error = %v", key, err)
+				}
+				want = append(want, left.fingerprint(key))
+			}
+			for i := 0; i < tt.rightElements; i++ {
+				key := []byte(fmt.Sprintf("right-%d", i))
+				if err := right.Add(key); err != nil {
+					t.Fatalf("Add(%s) error = %v", key, err)
+				}
+				want = append(want, right.fingerprint(key))
+			}
+
+			if err := left.Merge(right); err != nil {
+				t.Fatalf("Merge() error = %v", err)
+			}
+			if left.QuotientBits() != tt.expectedBits {
+				t.Errorf("Merge() gave %d quotient bits, want %d", left.QuotientBits(), tt.expectedBits)
+			}
+			checkQuotientFilter(t, left, want)
+			for i := 0; i < tt.rightElements; i++ {
+				if !left.Contains([]byte(fmt.Sprintf("right-%d", i))) {
+					t.Fatalf("Expected merged filter to contain right-%d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestQuotientFilterMergeIncompatible(t *testing.T) {
+	left, _ := NewQuotientFilter(10, 10, nil)
+	right, _ := NewQuotientFilter(10, 12, nil)
+	err := left.Merge(right)
+	var incompatible *IncompatibleError
+	if !errors.As(err, &incompatible) || incompatible.Field != "fingerprint bits" {
+		t.Fatalf("Merge() error = %v, expectedError %v", err, ErrIncompatible)
+	}
+	if !errors.Is(err, ErrIncompatible) {
+		t.Errorf("Expected error to match ErrIncompatible")
+	}
+}
+
+func TestQuotientFilterFingerprintsStop(t *testing.T) {
+	qf, _ := NewQuotientFilter(8, 8, nil)
+	for i := 0; i < 100; i++ {
+		if err := qf.Add([]byte(fmt.Sprintf("member-%d", i))); err != nil {
+			t.Fatalf("Add(member-%d) error = %v", i, err)
+		}
+	}
+	seen := 0
+	qf.Fingerprints()(func(uint64) bool {
+		seen++
+		return seen < 10
+	})
+	if seen != 10 {
+		t.Errorf("Fingerprints() yielded %d fingerprints after yield returned false, want 10", seen)
+	}
+}
+
+func TestQuotientFilterSaveLoad(t *testing.T) {
+	qf, err := NewQuotientFilter(10, 9, nil)
+	if err != nil {
+		t.Fatalf("NewQuotientFilter() error = %v", err)
+	}
+	for i := 0; i < 500; i++ {
+		if err := qf.Add([]byte(fmt.Sprintf("member-%d", i))); err != nil {
+			t.Fatalf("Add(member-%d) error = %v", i, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := qf.Save(&buf); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	saved := buf.Bytes()
+	var loaded QuotientFilter
+	if err := loaded.Load(bytes.NewReader(saved), nil); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.Count() != qf.Count() || loaded.QuotientBits() != 10 || loaded.RemainderBits() != 9 {
+		t.Errorf("Load() gave count %d with %d/%d bits, want %d with 10/9", loaded.Count(), loaded.QuotientBits(), loaded.RemainderBits(), qf.Count())
+	}
+	if !slices.Equal(loaded.slots, qf.slots) {
+		t.Errorf("Load() slots differ from the saved filter")
+	}
+	for i := 0; i < 500; i++ {
+		if !loaded.Contains([]byte(fmt.Sprintf("member-%d", i))) {
+			t.Fatalf("Expected loaded filter to contain member-%d", i)
+		}
+	}
+
+	// A frame of another kind is rejected.
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	cf, _ := NewCuckooFilter(100, 0.01, DefaultBucketSize, logger)
+	buf.Reset()
+	if err := cf.Save(&buf); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := loaded.Load(&buf, nil); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Load() error = %v, expectedError %v", err, ErrInvalidFormat)
+	}
+}
+
+func TestQuotientFilterLoadCorrupt(t *testing.T) {
+	const q = 6
+	overflow := uint64(1)<<q + 1
+
+	tests := []struct {
+		name  string
+		slots map[uint64]uint64
+		count uint
+	}{
+		{"Continuation without a run", map[uint64]uint64{40: 7<<qfMetaBits | qfContinuation | qfShifted}, 1},
+		{"Shifted run without an occupied slot", map[uint64]uint64{40: 7<<qfMetaBits | qfShifted}, 1},
+		{"Occupied slot without a run", map[uint64]uint64{4: 1<<qfMetaBits | qfOccupied, 5: 2<<qfMetaBits | qfOccupied | qfContinuation | qfShifted}, 2},
+		{"Run past an empty slot", map[uint64]uint64{4: 1<<qfMetaBits | qfOccupied, 5: qfOccupied, 7: 2<<qfMetaBits | qfShifted}, 3},
+		{"Unsorted run", map[uint64]uint64{4: 5<<qfMetaBits | qfOccupied, 5: 3<<qfMetaBits | qfContinuation | qfShifted}, 2},
+		{"Remainder in an empty slot", map[uint64]uint64{10: 5 << qfMetaBits}, 0},
+		{"Occupied overflow slot", map[uint64]uint64{overflow: 1<<qfMetaBits | qfOccupied}, 1},
+		{"Count does not match", map[uint64]uint64{4: 1<<qfMetaBits | qfOccupied}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The metadata is written directly, so the saved frame is well
+			// formed and its checksum valid.
+			qf, err := NewQuotientFilter(q, 8, nil)
+			if err != nil {
+				t.Fatalf("NewQuotientFilter() error = %v", err)
+			}
+			for i, e := range tt.slots {
+				qf.set(i, e)
+			}
+			qf.count = tt.count
+			var buf bytes.Buffer
+			if err := qf.Save(&buf); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+			var loaded QuotientFilter
+			if err := loaded.Load(&buf, nil); !errors.Is(err, ErrInvalidFormat) {
+				t.Errorf("Load() error = %v, expectedError %v", err, ErrInvalidFormat)
+			}
+		})
+	}
+}
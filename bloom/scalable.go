@@ -0,0 +1,229 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+const (
+	// DefaultGrowthFactor is the growth factor s recommended by Almeida et al. for moderate growth
+	DefaultGrowthFactor = 2
+	// DefaultTighteningRatio is the tightening ratio r recommended by Almeida et al.
+	DefaultTighteningRatio = 0.85
+)
+
+// ErrInvalidParameter is returned when a filter is constructed with parameters outside their valid range
+var ErrInvalidParameter = errors.New("bloom: invalid parameter")
+
+// ScalableFilter is a scalable Bloom filter (Almeida, Baquero, Preguiça and
+// Hutchison, 2007) that keeps its false positive rate bounded no matter how
+// many elements are added.
+//
+// It is a chain of plain Filters. When the newest one reaches its capacity a
+// new one is appended whose capacity is growthFactor times larger and whose
+// false positive rate is tighteningRatio times smaller. With a first sub-filter
+// error rate of P0 = P*(1-r), the compound rate 1 - Π(1 - P0*r^i) stays below
+// the target P for any number of sub-filters.
+type ScalableFilter struct {
+	filters           []*Filter
+	counts            []uint
+	initialCapacity   int
+	falsePositiveRate float64
+	growthFactor      uint
+	tighteningRatio   float64
+	logger            *slog.Logger
+}
+
+// NewScalableFilter creates a new scalable Bloom filter whose first sub-filter
+// holds initialCapacity elements and whose compound false positive rate stays
+// below falsePositiveRate. A nil logger discards log output.
+func NewScalableFilter(initialCapacity int, falsePositiveRate float64, growthFactor uint, tighteningRatio float64, logger *slog.Logger) (*ScalableFilter, error) {
+	if err := validateScalable(initialCapacity, falsePositiveRate, growthFactor, tighteningRatio); err != nil {
+		return nil, err
+	}
+
+	sf := &ScalableFilter{
+		initialCapacity:   initialCapacity,
+		falsePositiveRate: falsePositiveRate,
+		growthFactor:      growthFactor,
+		tighteningRatio:   tighteningRatio,
+		logger:            orDiscard(logger),
+	}
+	sf.grow()
+
+	sf.logger.Info("Created new scalable Bloom filter", "initialCapacity", initialCapacity, "falsePositiveRate", falsePositiveRate,
+		"growthFactor", growthFactor, "tighteningRatio", tighteningRatio)
+	return sf, nil
+}
+
+// validateScalable checks the parameters of a scalable filter. Load applies it
+// too, since a sub-filter capacity of zero would divide by zero on growth.
+func validateScalable(initialCapacity int, falsePositiveRate float64, growthFactor uint, tighteningRatio float64) error {
+	if initialCapacity <= 0 {
+		return fmt.Errorf("%w: initial capacity must be positive, got %d", ErrInvalidParameter, initialCapacity)
+	}
+	if !(falsePositiveRate > 0 && falsePositiveRate < 1) {
+		return fmt.Errorf("%w: false positive rate must be in (0, 1), got %v", ErrInvalidParameter, falsePositiveRate)
+	}
+	if growthFactor < 1 {
+		return fmt.Errorf("%w: growth factor must be at least 1, got %d", ErrInvalidParameter, growthFactor)
+	}
+	if !(tighteningRatio > 0 && tighteningRatio < 1) {
+		return fmt.Errorf("%w: tightening ratio must be in (0, 1), got %v", ErrInvalidParameter, tighteningRatio)
+	}
+	return nil
+}
+
+// Add adds an element to the scalable Bloom filter. Elements that already test
+// as present are not added again, so duplicates do not use up capacity.
+func (sf *ScalableFilter) Add(element []byte) {
+	if sf.Contains(element) {
+		return
+	}
+
+	last := len(sf.filters) - 1
+	if sf.counts[last] >= sf.capacity(last) {
+		sf.grow()
+		last++
+	}
+	sf.filters[last].Add(element)
+	sf.counts[last]++
+}
+
+// Contains checks if an element might be in any of the sub-filters
+func (sf *ScalableFilter) Contains(element []byte) bool {
+	// Newer sub-filters are larger and hold more elements, so check them first.
+	for i := len(sf.filters) - 1; i >= 0; i-- {
+		if sf.filters[i].Contains(element) {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns the number of elements added to the scalable Bloom filter
+func (sf *ScalableFilter) Count() uint {
+	total := uint(0)
+	for _, n := range sf.counts {
+		total += n
+	}
+	return total
+}
+
+// FalsePositiveRate calculates the current compound false positive rate from
+// the fill ratio of every sub-filter
+func (sf *ScalableFilter) FalsePositiveRate() float64 {
+	pass := 1.0
+	for _, f := range sf.filters {
+		pass *= 1 - f.FalsePositiveRate()
+	}
+	return 1 - pass
+}
+
+// FalsePositiveBound returns the compound false positive rate the current
+// sub-filters are designed for once they are all full. It never exceeds the
+// target rate passed to NewScalableFilter.
+func (sf *ScalableFilter) FalsePositiveBound() float64 {
+	pass := 1.0
+	for i := range sf.filters {
+		pass *= 1 - sf.subFalsePositiveRate(i)
+	}
+	return 1 - pass
+}
+
+// Save serializes the scalable Bloom filter, including every sub-filter, to a writer
+func (sf *ScalableFilter) Save(w io.Writer) error {
+	filters := make([][]byte, len(sf.filters))
+	for i, f := range sf.filters {
+		var buf bytes.Buffer
+		if err := f.Save(&buf); err != nil {
+			return err
+		}
+		filters[i] = buf.Bytes()
+	}
+
+	return writeGobFrame(w, kindScalable, struct {
+		InitialCapacity   int
+		FalsePositiveRate float64
+		GrowthFactor      uint
+		TighteningRatio   float64
+		Counts            []uint
+		Filters           [][]byte
+	}{
+		InitialCapacity:   sf.initialCapacity,
+		FalsePositiveRate: sf.falsePositiveRate,
+		GrowthFactor:      sf.growthFactor,
+		TighteningRatio:   sf.tighteningRatio,
+		Counts:            sf.counts,
+		Filters:           filters,
+	})
+}
+
+// Load deserializes the scalable Bloom filter from a reader
+func (sf *ScalableFilter) Load(r io.Reader, logger *slog.Logger) error {
+	var data struct {
+		InitialCapacity   int
+		FalsePositiveRate float64
+		GrowthFactor      uint
+		TighteningRatio   float64
+		Counts            []uint
+		Filters           [][]byte
+	}
+	if err := readGobFrame(r, kindScalable, &data); err != nil {
+		return err
+	}
+	if err := validateScalable(data.InitialCapacity, data.FalsePositiveRate, data.GrowthFactor, data.TighteningRatio); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidFormat, err)
+	}
+	if len(data.Filters) == 0 || len(data.Counts) != len(data.Filters) {
+		return fmt.Errorf("%w: scalable filter has %d sub-filters and %d counts", ErrInvalidFormat, len(data.Filters), len(data.Counts))
+	}
+
+	filters := make([]*Filter, len(data.Filters))
+	for i, encoded := range data.Filters {
+		filters[i] = &Filter{}
+		if err := filters[i].Load(bytes.NewReader(encoded), logger); err != nil {
+			return fmt.Errorf("bloom: loading sub-filter %d: %w", i, err)
+		}
+	}
+
+	sf.filters = filters
+	sf.counts = data.Counts
+	sf.initialCapacity = data.InitialCapacity
+	sf.falsePositiveRate = data.FalsePositiveRate
+	sf.growthFactor = data.GrowthFactor
+	sf.tighteningRatio = data.TighteningRatio
+	sf.logger = orDiscard(logger)
+	return nil
+}
+
+// grow appends a new sub-filter sized for the next capacity and error rate in the series
+func (sf *ScalableFilter) grow() {
+	i := len(sf.filters)
+	capacity := sf.capacity(i)
+	size := OptimalSize(int(capacity), sf.subFalsePositiveRate(i))
+	sf.filters = append(sf.filters, NewBloomFilter(size, OptimalHashFunctions(size, int(capacity)), sf.logger))
+	sf.counts = append(sf.counts, 0)
+	sf.logger.Info("Grew scalable Bloom filter", "subFilters", i+1, "capacity", capacity)
+}
+
+// capacity returns the number of elements sub-filter i is sized for, n0*s^i
+func (sf *ScalableFilter) capacity(i int) uint {
+	capacity := uint(sf.initialCapacity)
+	for ; i > 0; i-- {
+		capacity *= sf.growthFactor
+	}
+	return capacity
+}
+
+// subFalsePositiveRate returns the error rate sub-filter i is sized for, P*(1-r)*r^i
+func (sf *ScalableFilter) subFalsePositiveRate(i int) float64 {
+	rate := sf.falsePositiveRate * (1 - sf.tighteningRatio)
+	for ; i > 0; i-- {
+		rate *= sf.tighteningRatio
+	}
+	return rate
+}
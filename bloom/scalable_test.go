@@ -0,0 +1,139 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScalableFilterGrowth(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	const target = 0.01
+	sf, err := NewScalableFilter(1000, target, DefaultGrowthFactor, DefaultTighteningRatio, logger)
+	if err != nil {
+		t.Fatalf("NewScalableFilter returned error: %v", err)
+	}
+
+	// Insert twenty times the initial capacity.
+	const inserted = 20000
+	for i := 0; i < inserted; i++ {
+		sf.Add([]byte(fmt.Sprintf("member-%d", i)))
+	}
+
+	if len(sf.filters) < 4 {
+		t.Errorf("Expected the filter to grow to at least 4 sub-filters, got %d", len(sf.filters))
+	}
+	if bound := sf.FalsePositiveBound(); bound > target {
+		t.Errorf("Compound false positive bound %f exceeds target %f", bound, target)
+	}
+	for i := 0; i < inserted; i++ {
+		if !sf.Contains([]byte(fmt.Sprintf("member-%d", i))) {
+			t.Fatalf("Expected Contains(member-%d) to be true", i)
+		}
+	}
+
+	falsePositives := 0
+	const probes = 100000
+	for i := 0; i < probes; i++ {
+		if sf.Contains([]byte(fmt.Sprintf("probe-%d", i))) {
+			falsePositives++
+		}
+	}
+	actualFPR := float64(falsePositives) / probes
+	t.Logf("Sub-filters: %d, Actual FPR: %f, Estimated FPR: %f, Bound: %f", len(sf.filters), actualFPR, sf.FalsePositiveRate(), sf.FalsePositiveBound())
+	if actualFPR > target {
+		t.Errorf("Actual false positive rate %f exceeds target %f", actualFPR, target)
+	}
+}
+
+func TestScalableFilterInvalidParameters(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	tests := []struct {
+		name              string
+		initialCapacity   int
+		falsePositiveRate float64
+		growthFactor      uint
+		tighteningRatio   float64
+	}{
+		{"Zero capacity", 0, 0.01, 2, 0.85},
+		{"Zero false positive rate", 100, 0, 2, 0.85},
+		{"False positive rate of one", 100, 1, 2, 0.85},
+		{"Zero growth factor", 100, 0.01, 0, 0.85},
+		{"Tightening ratio of one", 100, 0.01, 2, 1},
+		{"NaN false positive rate", 100, math.NaN(), 2, 0.85},
+	}
+
+	// Load applies the same rules to a saved chain
+	_, subFilter := savedFilter(t, logger)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewScalableFilter(tt.initialCapacity, tt.falsePositiveRate, tt.growthFactor, tt.tighteningRatio, logger)
+			if !errors.Is(err, ErrInvalidParameter) {
+				t.Errorf("Expected ErrInvalidParameter, got %v", err)
+			}
+
+			var buf bytes.Buffer
+			err = writeGobFrame(&buf, kindScalable, struct {
+				InitialCapacity   int
+				FalsePositiveRate float64
+				GrowthFactor      uint
+				TighteningRatio   float64
+				Counts            []uint
+				Filters           [][]byte
+			}{tt.initialCapacity, tt.falsePositiveRate, tt.growthFactor, tt.tighteningRatio, []uint{3}, [][]byte{subFilter}})
+			if err != nil {
+				t.Fatalf("writeGobFrame() error = %v", err)
+			}
+			if err := (&ScalableFilter{}).Load(&buf, logger); !errors.Is(err, ErrInvalidFormat) {
+				t.Errorf("Load() error = %v, expectedError %v", err, ErrInvalidFormat)
+			}
+		})
+	}
+}
+
+func TestScalableFilterSaveAndLoad(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	original, err := NewScalableFilter(100, 0.01, 4, 0.8, logger)
+	if err != nil {
+		t.Fatalf("NewScalableFilter returned error: %v", err)
+	}
+	elements := generateRandomStrings(1000, 12)
+	for _, elem := range elements {
+		original.Add([]byte(elem))
+	}
+
+	filename := filepath.Join(t.TempDir(), "scalable.gob")
+	if err := SaveToFile(original, filename, logger); err != nil {
+		t.Fatalf("Failed to save scalable filter: %v", err)
+	}
+	loaded := &ScalableFilter{}
+	if err := LoadFromFile(filename, loaded, logger); err != nil {
+		t.Fatalf("Failed to load scalable filter: %v", err)
+	}
+
+	if len(loaded.filters) != len(original.filters) || loaded.Count() != original.Count() {
+		t.Fatalf("Loaded chain has %d sub-filters and %d elements, original has %d and %d",
+			len(loaded.filters), loaded.Count(), len(original.filters), original.Count())
+	}
+	for _, elem := range elements {
+		if !loaded.Contains([]byte(elem)) {
+			t.Errorf("Expected loaded filter to contain %s", elem)
+		}
+	}
+
+	// The loaded filter keeps growing along the same series.
+	for _, elem := range generateRandomStrings(5000, 12) {
+		loaded.Add([]byte(elem))
+	}
+	if bound := loaded.FalsePositiveBound(); bound > 0.01 {
+		t.Errorf("Compound false positive bound %f exceeds target after growing a loaded filter", bound)
+	}
+}
@@ -0,0 +1,117 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrIncompatible is matched by the *IncompatibleError returned when two
+// filters cannot be combined
+var ErrIncompatible = errors.New("bloom: filters are incompatible")
+
+// IncompatibleError describes the first difference found between two filters
+// that prevents combining them. It matches ErrIncompatible with errors.Is.
+type IncompatibleError struct {
+	// Field names what differs: "size", "hashes", "hasher" or "seed", or
+	// "fingerprint bits" for quotient filters
+	Field string
+	// Left and Right are the differing values of the receiver and the other
+	// filter. They are nil for "seed": a seed may be a secret key, and the
+	// error must not carry it out of the hasher.
+	Left, Right any
+}
+
+func (e *IncompatibleError) Error() string {
+	if e.Field == "seed" {
+		// Seeds may be secret keys, so they are never recorded.
+		return "bloom: incompatible filters: hasher seeds differ"
+	}
+	return fmt.Sprintf("bloom: incompatible filters: %s %v != %v", e.Field, e.Left, e.Right)
+}
+
+// Is reports whether target is ErrIncompatible
+func (e *IncompatibleError) Is(target error) bool {
+	return target == ErrIncompatible
+}
+
+// Compatible reports whether other can be combined with the filter by Union or
+// Intersect. Filters are compatible when they have the same size and number of
+// hash functions and hash with the same scheme and seed, so that an element
+// maps to the same bits in both. Otherwise it returns an *IncompatibleError.
+func (bf *Filter) Compatible(other *Filter) error {
+	switch {
+	case bf.size != other.size:
+		return &IncompatibleError{Field: "size", Left: bf.size, Right: other.size}
+	case bf.numHashFuncs != other.numHashFuncs:
+		return &IncompatibleError{Field: "hashes", Left: bf.numHashFuncs, Right: other.numHashFuncs}
+	case bf.hasher.Scheme() != other.hasher.Scheme():
+		return &IncompatibleError{Field: "hasher", Left: bf.hasher.Scheme(), Right: other.hasher.Scheme()}
+	case bf.hasher.Seed() != other.hasher.Seed():
+		return &IncompatibleError{Field: "seed"}
+	}
+	return nil
+}
+
+// Clone returns an independent copy of the filter
+func (bf *Filter) Clone() *Filter {
+	clone := *bf
+	clone.bits = slices.Clone(bf.bits)
+	return &clone
+}
+
+// Union adds every element of other to the filter by ORing their bits. The
+// result is exactly the filter that adding both sets of elements would have
+// built, so its false positive rate is that of a filter holding the union.
+func (bf *Filter) Union(other *Filter) error {
+	if err := bf.Compatible(other); err != nil {
+		return err
+	}
+	for i, w := range other.bits {
+		bf.bits[i] |= w
+	}
+	bf.logger.Info("Merged Bloom filter by union", "size", bf.size)
+	return nil
+}
+
+// Intersect keeps only the bits set in both the filter and other, so it still
+// contains every element added to both.
+//
+// Unlike Union, the result is not the filter that adding only the common
+// elements would have built: a bit also survives when different elements of
+// each filter happened to set it. It therefore answers true for more elements
+// than a filter of the intersection would. FalsePositiveRate, which raises the
+// fraction of set bits to the power of the number of hash functions, accounts
+// for those extra bits because it reads the bits actually set: it estimates
+// the rate of the intersected filter, which is at least the rate a filter
+// built from the common elements would have.
+func (bf *Filter) Intersect(other *Filter) error {
+	if err := bf.Compatible(other); err != nil {
+		return err
+	}
+	for i, w := range other.bits {
+		bf.bits[i] &= w
+	}
+	bf.logger.Info("Merged Bloom filter by intersection", "size", bf.size)
+	return nil
+}
+
+// UnionOf returns a new filter holding the elements of both a and b, leaving
+// them untouched. See Union.
+func UnionOf(a, b *Filter) (*Filter, error) {
+	union := a.Clone()
+	if err := union.Union(b); err != nil {
+		return nil, err
+	}
+	return union, nil
+}
+
+// IntersectionOf returns a new filter holding the elements common to a and b,
+// leaving them untouched. See Intersect for its false positive rate.
+func IntersectionOf(a, b *Filter) (*Filter, error) {
+	intersection := a.Clone()
+	if err := intersection.Intersect(b); err != nil {
+		return nil, err
+	}
+	return intersection, nil
+}
@@ -0,0 +1,137 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestUnionAndIntersect(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	a := NewBloomFilter(10000, 5, logger)
+	b := NewBloomFilter(10000, 5, logger)
+	both := NewBloomFilter(10000, 5, logger)
+	for i := 0; i < 200; i++ {
+		a.Add([]byte(fmt.Sprintf("a-%d", i)))
+		b.Add([]byte(fmt.Sprintf("b-%d", i)))
+		both.Add([]byte(fmt.Sprintf("a-%d", i)))
+		both.Add([]byte(fmt.Sprintf("b-%d", i)))
+	}
+	for i := 0; i < 50; i++ {
+		shared := []byte(fmt.Sprintf("shared-%d", i))
+		a.Add(shared)
+		b.Add(shared)
+		both.Add(shared)
+	}
+	aBits := slices.Clone(a.bits)
+
+	union, err := UnionOf(a, b)
+	if err != nil {
+		t.Fatalf("UnionOf() error = %v", err)
+	}
+	if !slices.Equal(union.bits, both.bits) {
+		t.Errorf("UnionOf() differs from a filter built from both sets")
+	}
+
+	intersection, err := IntersectionOf(a, b)
+	if err != nil {
+		t.Fatalf("IntersectionOf() error = %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		if !intersection.Contains([]byte(fmt.Sprintf("shared-%d", i))) {
+			t.Errorf("Expected intersection to contain shared-%d", i)
+		}
+	}
+	if intersection.FalsePositiveRate() > a.FalsePositiveRate() {
+		t.Errorf("Intersection FPR %v exceeds the FPR of an operand %v", intersection.FalsePositiveRate(), a.FalsePositiveRate())
+	}
+	if !slices.Equal(a.bits, aBits) {
+		t.Errorf("Copying operations modified their operand")
+	}
+
+	if err := a.Union(b); err != nil {
+		t.Fatalf("Union() error = %v", err)
+	}
+	if !slices.Equal(a.bits, union.bits) {
+		t.Errorf("Union() in place differs from UnionOf()")
+	}
+	if err := a.Intersect(intersection); err != nil {
+		t.Fatalf("Intersect() error = %v", err)
+	}
+	if !slices.Equal(a.bits, intersection.bits) {
+		t.Errorf("Intersect() with a subset should yield the subset")
+	}
+}
+
+func TestClone(t *testing.T) {
+	bf := NewBloomFilterWithHasher(1000, 3, NewMurmur3Hasher(7), nil)
+	bf.Add([]byte("hello"))
+	clone := bf.Clone()
+	clone.Add([]byte("world"))
+
+	if !clone.Contains([]byte("hello")) || !clone.Contains([]byte("world")) {
+		t.Errorf("Expected clone to contain hello and world")
+	}
+	if bf.Contains([]byte("world")) {
+		t.Errorf("Adding to the clone modified the original")
+	}
+	if err := bf.Compatible(clone); err != nil {
+		t.Errorf("Compatible() error = %v for a clone", err)
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	key := [16]byte{1, 2, 3}
+	base := NewBloomFilterWithHasher(1000, 3, NewXXHash64Hasher(1), nil)
+
+	tests := []struct {
+		name          string
+		other         *Filter
+		expectedField string
+	}{
+		{"Same parameters", NewBloomFilterWithHasher(1000, 3, NewXXHash64Hasher(1), nil), ""},
+		{"Different size", NewBloomFilterWithHasher(2000, 3, NewXXHash64Hasher(1), nil), "size"},
+		{"Different hashes", NewBloomFilterWithHasher(1000, 4, NewXXHash64Hasher(1), nil), "hashes"},
+		{"Different hasher", NewBloomFilterWithHasher(1000, 3, NewMurmur3Hasher(1), nil), "hasher"},
+		{"Different seed", NewBloomFilterWithHasher(1000, 3, NewXXHash64Hasher(2), nil), "seed"},
+		{"Keyed filter", NewKeyedBloomFilter(1000, 3, key, nil), "hasher"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := base.Compatible(tt.other)
+			if tt.expectedField == "" {
+				if err != nil {
+					t.Errorf("Compatible() error = %v, expectedError nil", err)
+				}
+				return
+			}
+			var incompatible *IncompatibleError
+			if !errors.As(err, &incompatible) || incompatible.Field != tt.expectedField {
+				t.Fatalf("Compatible() error = %v, want mismatch in %q", err, tt.expectedField)
+			}
+			if !errors.Is(err, ErrIncompatible) {
+				t.Errorf("Expected error to match ErrIncompatible")
+			}
+			if err := base.Clone().Union(tt.other); !errors.Is(err, ErrIncompatible) {
+				t.Errorf("Union() error = %v, expectedError %v", err, ErrIncompatible)
+			}
+			if _, err := IntersectionOf(base, tt.other); !errors.Is(err, ErrIncompatible) {
+				t.Errorf("IntersectionOf() error = %v, expectedError %v", err, ErrIncompatible)
+			}
+		})
+	}
+
+	// Two keyed filters under different keys must not reveal their keys in the error.
+	err := NewKeyedBloomFilter(1000, 3, key, nil).Compatible(NewKeyedBloomFilter(1000, 3, [16]byte{9}, nil))
+	if !errors.Is(err, ErrIncompatible) || err.Error() != "bloom: incompatible filters: hasher seeds differ" {
+		t.Errorf("Compatible() error = %v for different keys", err)
+	}
+	var incompatible *IncompatibleError
+	if !errors.As(err, &incompatible) || incompatible.Left != nil || incompatible.Right != nil {
+		t.Errorf("Compatible() error = %#v, want a seed mismatch without the seeds", err)
+	}
+}
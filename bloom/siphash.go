@@ -0,0 +1,68 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// sipHash128 computes SipHash-2-4 with 128-bit output of b under the key
+// (k0, k1), as specified by Aumasson and Bernstein. The two halves are
+// returned in the order they appear in the reference output.
+func sipHash128(b []byte, k0, k1 uint64) (uint64, uint64) {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+	// The 128-bit variant differs from the 64-bit one in this constant and in
+	// the finalization below.
+	v1 ^= 0xee
+
+	n := len(b)
+	for ; len(b) >= 8; b = b[8:] {
+		m := binary.LittleEndian.Uint64(b)
+		v3 ^= m
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0 ^= m
+	}
+
+	m := uint64(n) << 56
+	for i := len(b) - 1; i >= 0; i-- {
+		m |= uint64(b[i]) << (8 * uint(i))
+	}
+	v3 ^= m
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0 ^= m
+
+	v2 ^= 0xee
+	for i := 0; i < 4; i++ {
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	}
+	first := v0 ^ v1 ^ v2 ^ v3
+
+	v1 ^= 0xdd
+	for i := 0; i < 4; i++ {
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	}
+	second := v0 ^ v1 ^ v2 ^ v3
+	return first, second
+}
+
+func sipRound(v0, v1, v2, v3 uint64) (uint64, uint64, uint64, uint64) {
+	v0 += v1
+	v1 = bits.RotateLeft64(v1, 13)
+	v1 ^= v0
+	v0 = bits.RotateLeft64(v0, 32)
+	v2 += v3
+	v3 = bits.RotateLeft64(v3, 16)
+	v3 ^= v2
+	v0 += v3
+	v3 = bits.RotateLeft64(v3, 21)
+	v3 ^= v0
+	v2 += v1
+	v1 = bits.RotateLeft64(v1, 17)
+	v1 ^= v2
+	v2 = bits.RotateLeft64(v2, 32)
+	return v0, v1, v2, v3
+}
@@ -0,0 +1,252 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/bits"
+)
+
+const (
+	// splitBlockWords is the number of 32-bit words in a split block
+	splitBlockWords = 8
+	// splitBlockBytes is the size of a split block in bytes
+	splitBlockBytes = 4 * splitBlockWords
+	// MinSplitBlockBytes and MaxSplitBlockBytes bound the bitset sizes
+	// OptimalSplitBlockBytes returns, as Parquet writers do
+	MinSplitBlockBytes = 32
+	MaxSplitBlockBytes = 128 << 20
+)
+
+// splitBlockSalts are the salts the Parquet specification uses to derive the
+// bit set in each word of a block
+var splitBlockSalts = [splitBlockWords]uint32{
+	0x47b6137b, 0x44974d91, 0x8824ad5b, 0xa2b7289d,
+	0x705495c7, 0x2df1424b, 0x9efc4947, 0x5c6bfb31,
+}
+
+// SplitBlockFilter is the split block Bloom filter (SBBF) of the Apache
+// Parquet specification, implemented bit for bit so that filters can be
+// exchanged with Parquet readers and writers.
+//
+// The bitset is an array of 256-bit blocks of eight 32-bit words. An element
+// is hashed with xxHash64 and seed 0; the upper 32 bits of the hash select a
+// block and the lower 32 bits, multiplied by a different salt for each word,
+// set exactly one bit in each of its eight words.
+//
+// Parquet hashes the plain encoding of a value: the bytes themselves for
+// BYTE_ARRAY and FIXED_LEN_BYTE_ARRAY columns, which Add and Contains accept,
+// and 4 or 8 little-endian bytes for numeric columns, which the ParquetHash
+// functions produce for AddHash and ContainsHash.
+type SplitBlockFilter struct {
+	blocks [][splitBlockWords]uint32
+	logger *slog.Logger
+}
+
+// NewSplitBlockFilter creates a new split block Bloom filter with a bitset of
+// numBytes bytes, which must be a positive multiple of 32. Parquet writers use
+// powers of two between MinSplitBlockBytes and MaxSplitBlockBytes; see
+// OptimalSplitBlockBytes. A nil logger discards log output.
+func NewSplitBlockFilter(numBytes uint, logger *slog.Logger) (*SplitBlockFilter, error) {
+	if numBytes == 0 || numBytes%splitBlockBytes != 0 {
+		return nil, fmt.Errorf("%w: bitset size must be a positive multiple of %d bytes, got %d", ErrInvalidParameter, splitBlockBytes, numBytes)
+	}
+	logger = orDiscard(logger)
+	sbf := &SplitBlockFilter{
+		blocks: make([][splitBlockWords]uint32, numBytes/splitBlockBytes),
+		logger: logger,
+	}
+
+	sbf.logger.Info("Created new split block Bloom filter", "numBytes", numBytes)
+	return sbf, nil
+}
+
+// OptimalSplitBlockBytes calculates the bitset size in bytes that Parquet
+// writers choose for distinctValues values at falsePositiveRate: the size
+// from the SBBF false positive formula, clamped to [MinSplitBlockBytes,
+// MaxSplitBlockBytes] and rounded up to a power of two.
+func OptimalSplitBlockBytes(distinctValues int, falsePositiveRate float64) uint {
+	// Each element sets one bit in each of 8 words, so the classic rate with
+	// k = 8 solves to m = -8n / ln(1 - p^(1/8)) bits.
+	numBits := -8 * float64(distinctValues) / math.Log(1-math.Pow(falsePositiveRate, 1.0/8))
+	if !(numBits >= 0) || numBits > MaxSplitBlockBytes*8 {
+		numBits = MaxSplitBlockBytes * 8
+	}
+	numBytes := max(uint(numBits)/8, MinSplitBlockBytes)
+	if numBytes&(numBytes-1) != 0 {
+		numBytes = 1 << bits.Len(numBytes)
+	}
+	return min(numBytes, MaxSplitBlockBytes)
+}
+
+// ParquetHash returns the hash Parquet uses for a BYTE_ARRAY or
+// FIXED_LEN_BYTE_ARRAY value: xxHash64 of its bytes with seed 0
+func ParquetHash(value []byte) uint64 {
+	return xxhash64(value, 0)
+}
+
+// ParquetHashInt32 returns the hash Parquet uses for an INT32 value
+func ParquetHashInt32(v int32) uint64 {
+	return ParquetHash(binary.LittleEndian.AppendUint32(nil, uint32(v)))
+}
+
+// ParquetHashInt64 returns the hash Parquet uses for an INT64 value
+func ParquetHashInt64(v int64) uint64 {
+	return ParquetHash(binary.LittleEndian.AppendUint64(nil, uint64(v)))
+}
+
+// ParquetHashFloat32 returns the hash Parquet uses for a FLOAT value
+func ParquetHashFloat32(v float32) uint64 {
+	return ParquetHash(binary.LittleEndian.AppendUint32(nil, math.Float32bits(v)))
+}
+
+// ParquetHashFloat64 returns the hash Parquet uses for a DOUBLE value
+func ParquetHashFloat64(v float64) uint64 {
+	return ParquetHash(binary.LittleEndian.AppendUint64(nil, math.Float64bits(v)))
+}
+
+// Add adds a BYTE_ARRAY or FIXED_LEN_BYTE_ARRAY value to the filter
+func (sbf *SplitBlockFilter) Add(value []byte) {
+	sbf.AddHash(ParquetHash(value))
+	sbf.logger.Info("Added element to split block Bloom filter", "element", string(value))
+}
+
+// Contains checks if a BYTE_ARRAY or FIXED_LEN_BYTE_ARRAY value might be in the filter
+func (sbf *SplitBlockFilter) Contains(value []byte) bool {
+	return sbf.ContainsHash(ParquetHash(value))
+}
+
+// AddHash adds an element by its 64-bit hash, e.g. from ParquetHashInt64
+func (sbf *SplitBlockFilter) AddHash(hash uint64) {
+	block := &sbf.blocks[sbf.blockIndex(hash)]
+	mask := splitBlockMask(uint32(hash))
+	for i := range block {
+		block[i] |= mask[i]
+	}
+}
+
+// ContainsHash checks if an element with the given 64-bit hash might be in the filter
+func (sbf *SplitBlockFilter) ContainsHash(hash uint64) bool {
+	block := &sbf.blocks[sbf.blockIndex(hash)]
+	mask := splitBlockMask(uint32(hash))
+	for i := range block {
+		if block[i]&mask[i] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// FalsePositiveRate calculates the current false positive rate of the filter:
+// the mean over blocks of the product of the fraction of bits set in each word
+func (sbf *SplitBlockFilter) FalsePositiveRate() float64 {
+	sum := 0.0
+	for _, block := range sbf.blocks {
+		rate := 1.0
+		for _, w := range block {
+			rate *= float64(bits.OnesCount32(w)) / 32
+		}
+		sum += rate
+	}
+	return sum / float64(len(sbf.blocks))
+}
+
+// NumBytes returns the size of the bitset in bytes
+func (sbf *SplitBlockFilter) NumBytes() uint {
+	return uint(len(sbf.blocks)) * splitBlockBytes
+}
+
+// MarshalBinary returns the bitset exactly as Parquet stores it after the
+// BloomFilterHeader: the blocks in order, each as eight little-endian words
+func (sbf *SplitBlockFilter) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, sbf.NumBytes())
+	for _, block := range sbf.blocks {
+		for _, w := range block {
+			data = binary.LittleEndian.AppendUint32(data, w)
+		}
+	}
+	return data, nil
+}
+
+// UnmarshalBinary replaces the bitset with one in the layout Parquet stores,
+// e.g. read from a column chunk at the offset and length given by its
+// BloomFilterHeader. The length must be a positive multiple of 32 bytes.
+func (sbf *SplitBlockFilter) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || len(data)%splitBlockBytes != 0 {
+		return fmt.Errorf("%w: bitset of %d bytes is not a positive multiple of %d", ErrInvalidFormat, len(data), splitBlockBytes)
+	}
+	blocks := make([][splitBlockWords]uint32, len(data)/splitBlockBytes)
+	for i := range blocks {
+		for j := range blocks[i] {
+			blocks[i][j] = binary.LittleEndian.Uint32(data[i*splitBlockBytes+j*4:])
+		}
+	}
+	sbf.blocks = blocks
+	if sbf.logger == nil {
+		sbf.logger = slog.New(discardHandler{})
+	}
+	return nil
+}
+
+// Save serializes the filter to a writer in the framed binary format, with the
+// Parquet bitset as the body
+func (sbf *SplitBlockFilter) Save(w io.Writer) error {
+	data, err := sbf.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	h := frameHeader{kind: kindSplitBlock, scheme: HashXXHash64, bodyLen: uint64(len(data))}
+	return writeFrame(w, h, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// Load deserializes the filter from a reader
+func (sbf *SplitBlockFilter) Load(r io.Reader, logger *slog.Logger) error {
+	var data []byte
+	err := readFrame(r, kindSplitBlock, func(h frameHeader, r io.Reader) error {
+		if h.scheme != HashXXHash64 || h.seed != ([16]byte{}) || h.flags != 0 {
+			return fmt.Errorf("%w: split block filters hash with unseeded xxHash64, not scheme %d", ErrInvalidFormat, h.scheme)
+		}
+		if h.bodyLen > math.MaxInt {
+			return fmt.Errorf("%w: body of %d bytes", ErrInvalidFormat, h.bodyLen)
+		}
+		// r stops at the end of the body. Reading it whole rather than into a
+		// buffer of bodyLen bytes keeps a corrupt length from sizing the buffer.
+		var err error
+		if data, err = io.ReadAll(r); err != nil {
+			return err
+		}
+		if uint64(len(data)) != h.bodyLen {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := sbf.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	sbf.logger = orDiscard(logger)
+	return nil
+}
+
+// blockIndex selects a block with the upper 32 bits of the hash, as the
+// specification requires: ((hash >> 32) * numBlocks) >> 32
+func (sbf *SplitBlockFilter) blockIndex(hash uint64) uint64 {
+	return ((hash >> 32) * uint64(len(sbf.blocks))) >> 32
+}
+
+// splitBlockMask returns the bit each word of a block must have set for key,
+// the lower 32 bits of the hash
+func splitBlockMask(key uint32) [splitBlockWords]uint32 {
+	var mask [splitBlockWords]uint32
+	for i, salt := range splitBlockSalts {
+		mask[i] = 1 << ((key * salt) >> 27)
+	}
+	return mask
+}
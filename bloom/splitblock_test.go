@@ -0,0 +1,168 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestSplitBlockFilterLayout(t *testing.T) {
+	sbf, err := NewSplitBlockFilter(128, nil)
+	if err != nil {
+		t.Fatalf("NewSplitBlockFilter() error = %v", err)
+	}
+
+	// With a key of 1 each word's bit index is the top five bits of its salt,
+	// and an upper half of 0x80000000 selects block 2 of 4.
+	sbf.AddHash(0x80000000_00000001)
+	expectedWords := []uint32{1 << 8, 1 << 8, 1 << 17, 1 << 20, 1 << 14, 1 << 5, 1 << 19, 1 << 11}
+	expected := make([]byte, 128)
+	for i, w := range expectedWords {
+		binary.LittleEndian.PutUint32(expected[2*splitBlockBytes+4*i:], w)
+	}
+
+	data, err := sbf.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() error = %v", err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("MarshalBinary() = %x, want %x", data, expected)
+	}
+	if !sbf.ContainsHash(0x80000000_00000001) || sbf.ContainsHash(0x00000000_00000001) {
+		t.Errorf("ContainsHash() did not select the block from the upper 32 bits")
+	}
+}
+
+func TestParquetHash(t *testing.T) {
+	tests := []struct {
+		name     string
+		hash     uint64
+		expected uint64
+	}{
+		// xxHash64 of the empty input with seed 0
+		{"Empty byte array", ParquetHash(nil), 0xef46db3751d8e999},
+		{"INT32 is 4 little-endian bytes", ParquetHashInt32(-2), ParquetHash([]byte{0xfe, 0xff, 0xff, 0xff})},
+		{"INT64 is 8 little-endian bytes", ParquetHashInt64(258), ParquetHash([]byte{2, 1, 0, 0, 0, 0, 0, 0})},
+		{"FLOAT is its IEEE bits", ParquetHashFloat32(1), ParquetHash([]byte{0, 0, 0x80, 0x3f})},
+		{"DOUBLE is its IEEE bits", ParquetHashFloat64(1), ParquetHash([]byte{0, 0, 0, 0, 0, 0, 0xf0, 0x3f})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.hash != tt.expected {
+				t.Errorf("hash = %#x, want %#x", tt.hash, tt.expected)
+			}
+		})
+	}
+}
+
+func TestOptimalSplitBlockBytes(t *testing.T) {
+	tests := []struct {
+		name              string
+		distinctValues    int
+		falsePositiveRate float64
+		expected          uint
+	}{
+		{"Minimum size", 1, 0.01, MinSplitBlockBytes},
+		{"Rounded up to a power of two", 1000, 0.01, 2048},
+		{"Million values at one percent", 1_000_000, 0.01, 2 << 20},
+		{"Clamped to the maximum", 1 << 30, 0.001, MaxSplitBlockBytes},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := OptimalSplitBlockBytes(tt.distinctValues, tt.falsePositiveRate); got != tt.expected {
+				t.Errorf("OptimalSplitBlockBytes() = %d, want %d", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSplitBlockFilterFalsePositiveRate(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	const n, probes = 20000, 200000
+	sbf, err := NewSplitBlockFilter(OptimalSplitBlockBytes(n, 0.01), logger)
+	if err != nil {
+		t.Fatalf("NewSplitBlockFilter() error = %v", err)
+	}
+	for i := 0; i < n; i++ {
+		sbf.Add([]byte(fmt.Sprintf("member-%d", i)))
+		sbf.AddHash(ParquetHashInt64(int64(i)))
+	}
+	for i := 0; i < n; i++ {
+		if !sbf.Contains([]byte(fmt.Sprintf("member-%d", i))) || !sbf.ContainsHash(ParquetHashInt64(int64(i))) {
+			t.Fatalf("Expected split block filter to contain member %d", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := 0; i < probes; i++ {
+		if sbf.Contains([]byte(fmt.Sprintf("probe-%d", i))) {
+			falsePositives++
+		}
+	}
+	actualFPR := float64(falsePositives) / probes
+	estimated := sbf.FalsePositiveRate()
+	tolerance := 5 * math.Sqrt(estimated*(1-estimated)/probes)
+	t.Logf("Actual FPR: %f, Estimated FPR: %f", actualFPR, estimated)
+	if math.Abs(actualFPR-estimated) > tolerance {
+		t.Errorf("FalsePositiveRate() = %f, measured %f", estimated, actualFPR)
+	}
+}
+
+func TestSplitBlockFilterSerialization(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	sbf, err := NewSplitBlockFilter(1024, logger)
+	if err != nil {
+		t.Fatalf("NewSplitBlockFilter() error = %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		sbf.Add([]byte(fmt.Sprintf("element-%d", i)))
+	}
+	raw, _ := sbf.MarshalBinary()
+
+	fromRaw := &SplitBlockFilter{}
+	if err := fromRaw.UnmarshalBinary(raw); err != nil {
+		t.Fatalf("UnmarshalBinary() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := sbf.Save(&buf); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	fromFrame := &SplitBlockFilter{}
+	if err := fromFrame.Load(bytes.NewReader(buf.Bytes()), logger); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	for name, loaded := range map[string]*SplitBlockFilter{"UnmarshalBinary": fromRaw, "Load": fromFrame} {
+		data, _ := loaded.MarshalBinary()
+		if !bytes.Equal(data, raw) || loaded.NumBytes() != 1024 {
+			t.Errorf("%s() did not restore the bitset", name)
+		}
+		for i := 0; i < 100; i++ {
+			if !loaded.Contains([]byte(fmt.Sprintf("element-%d", i))) {
+				t.Errorf("Expected filter restored by %s() to contain element-%d", name, i)
+			}
+		}
+	}
+
+	for _, size := range []int{0, 31, 48} {
+		if err := (&SplitBlockFilter{}).UnmarshalBinary(make([]byte, size)); !errors.Is(err, ErrInvalidFormat) {
+			t.Errorf("UnmarshalBinary(%d bytes) error = %v, expectedError %v", size, err, ErrInvalidFormat)
+		}
+		if _, err := NewSplitBlockFilter(uint(size), logger); !errors.Is(err, ErrInvalidParameter) {
+			t.Errorf("NewSplitBlockFilter(%d) error = %v, expectedError %v", size, err, ErrInvalidParameter)
+		}
+	}
+
+	// A header claiming 2^50 body bytes followed by one filter's worth
+	huge := append(frameHeader{kind: kindSplitBlock, scheme: HashXXHash64, bodyLen: 1 << 50}.marshal(), raw...)
+	if err := (&SplitBlockFilter{}).Load(bytes.NewReader(huge), logger); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Load() error = %v, expectedError %v", err, ErrTruncated)
+	}
+}
@@ -0,0 +1,253 @@
+package bloom
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/bits"
+	"math/rand/v2"
+)
+
+// StableFilter is a stable Bloom filter (Deng and Rafiei, "Approximately
+// Detecting Duplicates for Streaming Data using Stable Bloom Filters", 2006)
+// for duplicate detection on unbounded streams. Each of its cells is a small
+// counter with maximum value Max = 2^cellBits - 1. Adding an element first
+// decrements P randomly chosen cells and then sets the element's k cells to
+// Max; a query checks that all k cells are non-zero.
+//
+// The random decrements evict old elements at the rate new ones arrive, so
+// the fraction of zero cells, and with it the false positive rate, converges
+// to a stable point (see StableFalsePositiveRate) instead of approaching 1 as
+// in a Filter that is added to forever. In exchange an element added long ago
+// may be forgotten: a stable filter has false negatives as well as false
+// positives. OptimalStableDecrements picks P for a target false positive rate.
+type StableFilter struct {
+	cells        []uint64
+	cellBits     uint
+	size         uint
+	numHashFuncs uint
+	decrements   uint
+	rng          uint64
+	logger       *slog.Logger
+}
+
+// NewStableFilter creates a new stable Bloom filter with size cells of
+// cellBits bits (1, 2, 4 or 8) that sets numHashFuncs cells per element and
+// decrements decrements cells, P in the paper, before each insert. numHashFuncs
+// and decrements must be between 1 and size. A nil logger discards log output.
+func NewStableFilter(size, numHashFuncs, cellBits, decrements uint, logger *slog.Logger) (*StableFilter, error) {
+	if err := validateStable(size, numHashFuncs, cellBits); err != nil {
+		return nil, err
+	}
+	if decrements < 1 || decrements > size {
+		return nil, fmt.Errorf("%w: decrements must be between 1 and the size %d, got %d", ErrInvalidParameter, size, decrements)
+	}
+	logger = orDiscard(logger)
+	perWord := 64 / cellBits
+	sf := &StableFilter{
+		cells:        make([]uint64, (size+perWord-1)/perWord),
+		cellBits:     cellBits,
+		size:         size,
+		numHashFuncs: numHashFuncs,
+		decrements:   decrements,
+		rng:          rand.Uint64(),
+		logger:       logger,
+	}
+
+	sf.logger.Info("Created new stable Bloom filter", "size", size, "numHashFuncs", numHashFuncs, "cellBits", cellBits, "decrements", decrements)
+	return sf, nil
+}
+
+// validateStable checks the parameters shared by NewStableFilter and OptimalStableDecrements
+func validateStable(size, numHashFuncs, cellBits uint) error {
+	if size == 0 {
+		return fmt.Errorf("%w: size must be positive", ErrInvalidParameter)
+	}
+	if numHashFuncs < 1 || numHashFuncs > size {
+		return fmt.Errorf("%w: hash function count must be between 1 and the size %d, got %d", ErrInvalidParameter, size, numHashFuncs)
+	}
+	if cellBits != 1 && cellBits != 2 && cellBits != 4 && cellBits != 8 {
+		return fmt.Errorf("%w: cell size must be 1, 2, 4 or 8 bits, got %d", ErrInvalidParameter, cellBits)
+	}
+	return nil
+}
+
+// OptimalStableDecrements calculates the number of cells to decrement per
+// insert, P, that makes a stable filter with the given size, hash function
+// count and cell size settle at the target false positive rate. It inverts
+// the stable point of Deng and Rafiei: the fraction of zero cells converges to
+//
+//	z = (1 / (1 + 1/(P·(1/k - 1/m))))^Max
+//
+// and the false positive rate to (1-z)^k. The result is at least 1 and at
+// most size.
+func OptimalStableDecrements(size, numHashFuncs, cellBits uint, falsePositiveRate float64) (uint, error) {
+	if err := validateStable(size, numHashFuncs, cellBits); err != nil {
+		return 0, err
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return 0, fmt.Errorf("%w: false positive rate must be in (0, 1), got %v", ErrInvalidParameter, falsePositiveRate)
+	}
+	maxValue := float64(uint(1)<<cellBits - 1)
+	zeros := 1 - math.Pow(falsePositiveRate, 1/float64(numHashFuncs))
+	decrements := 1 / ((math.Pow(zeros, -1/maxValue) - 1) * (1/float64(numHashFuncs) - 1/float64(size)))
+	if math.IsNaN(decrements) || decrements > float64(size) {
+		return size, nil
+	}
+	return max(1, uint(math.Round(decrements))), nil
+}
+
+// Add adds an element to the stable Bloom filter
+func (sf *StableFilter) Add(element []byte) {
+	h1, h2 := hashElement(element)
+	sf.add(h1, h2)
+	sf.logger.Info("Added element to stable Bloom filter", "element", string(element))
+}
+
+// Contains checks if an element might have been added recently
+func (sf *StableFilter) Contains(element []byte) bool {
+	h1, h2 := hashElement(element)
+	for i := uint(0); i < sf.numHashFuncs; i++ {
+		if sf.cell(location(h1, h2, i, sf.size)) == 0 {
+			sf.logger.Debug("Element not found in stable Bloom filter", "element", string(element), "hashFunc", i)
+			return false
+		}
+	}
+	sf.logger.Info("Element possibly in stable Bloom filter", "element", string(element))
+	return true
+}
+
+// AddAndTest adds an element and reports whether it tested as present
+// beforehand, that is whether it is probably a duplicate. It hashes the
+// element once and is the primitive a stream deduplicator needs.
+func (sf *StableFilter) AddAndTest(element []byte) bool {
+	h1, h2 := hashElement(element)
+	seen := true
+	for i := uint(0); i < sf.numHashFuncs && seen; i++ {
+		seen = sf.cell(location(h1, h2, i, sf.size)) != 0
+	}
+	sf.add(h1, h2)
+	sf.logger.Info("Added element to stable Bloom filter", "element", string(element), "seen", seen)
+	return seen
+}
+
+// FalsePositiveRate calculates the current false positive rate of the stable
+// Bloom filter from the fraction of non-zero cells
+func (sf *StableFilter) FalsePositiveRate() float64 {
+	return math.Pow(1-sf.zeroFraction(), float64(sf.numHashFuncs))
+}
+
+// StableFalsePositiveRate returns the false positive rate the filter converges
+// to as elements keep arriving, whatever it started from
+func (sf *StableFilter) StableFalsePositiveRate() float64 {
+	zeros := math.Pow(1/(1+1/(float64(sf.decrements)*(1/float64(sf.numHashFuncs)-1/float64(sf.size)))), float64(sf.maxValue()))
+	return math.Pow(1-zeros, float64(sf.numHashFuncs))
+}
+
+// Save serializes the stable Bloom filter to a writer, including the state of
+// its random number generator
+func (sf *StableFilter) Save(w io.Writer) error {
+	return writeGobFrame(w, kindStable, struct {
+		Cells      []uint64
+		CellBits   uint
+		Size       uint
+		NumHash    uint
+		Decrements uint
+		RNG        uint64
+	}{
+		Cells:      sf.cells,
+		CellBits:   sf.cellBits,
+		Size:       sf.size,
+		NumHash:    sf.numHashFuncs,
+		Decrements: sf.decrements,
+		RNG:        sf.rng,
+	})
+}
+
+// Load deserializes the stable Bloom filter from a reader
+func (sf *StableFilter) Load(r io.Reader, logger *slog.Logger) error {
+	var data struct {
+		Cells      []uint64
+		CellBits   uint
+		Size       uint
+		NumHash    uint
+		Decrements uint
+		RNG        uint64
+	}
+	if err := readGobFrame(r, kindStable, &data); err != nil {
+		return err
+	}
+	if err := validateStable(data.Size, data.NumHash, data.CellBits); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidFormat, err)
+	}
+	// A size near 2^64 would overflow the word count below
+	if err := checkFilterParams(uint64(data.Size), uint64(data.NumHash)); err != nil {
+		return err
+	}
+	if data.Decrements < 1 || data.Decrements > data.Size {
+		return fmt.Errorf("%w: %d decrements for size %d", ErrInvalidFormat, data.Decrements, data.Size)
+	}
+	perWord := 64 / data.CellBits
+	if uint(len(data.Cells)) != (data.Size+perWord-1)/perWord {
+		return fmt.Errorf("%w: cell array has %d words, want %d for size %d", ErrInvalidFormat, len(data.Cells), (data.Size+perWord-1)/perWord, data.Size)
+	}
+
+	sf.cells = data.Cells
+	sf.cellBits = data.CellBits
+	sf.size = data.Size
+	sf.numHashFuncs = data.NumHash
+	sf.decrements = data.Decrements
+	sf.rng = data.RNG
+	sf.logger = orDiscard(logger)
+	return nil
+}
+
+// add decrements P random cells and then sets the cells of the hashed element to Max
+func (sf *StableFilter) add(h1, h2 uint64) {
+	sf.decrement()
+	for i := uint(0); i < sf.numHashFuncs; i++ {
+		sf.setCell(location(h1, h2, i, sf.size), sf.maxValue())
+	}
+}
+
+// decrement decrements P cells chosen uniformly at random, leaving zero cells at zero
+func (sf *StableFilter) decrement() {
+	for i := uint(0); i < sf.decrements; i++ {
+		index, _ := bits.Mul64(splitmix64(&sf.rng), uint64(sf.size))
+		if value := sf.cell(index); value > 0 {
+			sf.setCell(index, value-1)
+		}
+	}
+}
+
+// zeroFraction returns the fraction of cells that are zero
+func (sf *StableFilter) zeroFraction() float64 {
+	zeros := 0
+	for i := uint64(0); i < uint64(sf.size); i++ {
+		if sf.cell(i) == 0 {
+			zeros++
+		}
+	}
+	return float64(zeros) / float64(sf.size)
+}
+
+// maxValue returns the value the cells of an added element are set to
+func (sf *StableFilter) maxValue() uint64 {
+	return 1<<sf.cellBits - 1
+}
+
+// cell returns the value of cell i
+func (sf *StableFilter) cell(i uint64) uint64 {
+	perWord := uint64(64 / sf.cellBits)
+	shift := (i % perWord) * uint64(sf.cellBits)
+	return (sf.cells[i/perWord] >> shift) & sf.maxValue()
+}
+
+// setCell stores value in cell i
+func (sf *StableFilter) setCell(i uint64, value uint64) {
+	perWord := uint64(64 / sf.cellBits)
+	shift := (i % perWord) * uint64(sf.cellBits)
+	word := &sf.cells[i/perWord]
+	*word = *word&^(sf.maxValue()<<shift) | value<<shift
+}
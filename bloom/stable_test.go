@@ -0,0 +1,225 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestStableFilter(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	tests := []struct {
+		name              string
+		size              uint
+		numHashFuncs      uint
+		cellBits          uint
+		falsePositiveRate float64
+	}{
+		{"One-bit cells", 20000, 3, 1, 0.01},
+		{"Two-bit cells", 20000, 4, 2, 0.01},
+		{"Four-bit cells", 20000, 2, 4, 0.005},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decrements, err := OptimalStableDecrements(tt.size, tt.numHashFuncs, tt.cellBits, tt.falsePositiveRate)
+			if err != nil {
+				t.Fatalf("OptimalStableDecrements() error = %v", err)
+			}
+			sf, err := NewStableFilter(tt.size, tt.numHashFuncs, tt.cellBits, decrements, logger)
+			if err != nil {
+				t.Fatalf("NewStableFilter() error = %v", err)
+			}
+			if stable := sf.StableFalsePositiveRate(); math.Abs(stable-tt.falsePositiveRate) > 0.1*tt.falsePositiveRate {
+				t.Errorf("StableFalsePositiveRate() = %f with %d decrements, want about %f", stable, decrements, tt.falsePositiveRate)
+			}
+
+			// Ten times as many elements as cells is far past the stable point;
+			// a Filter of the same size would be saturated.
+			for i := 0; i < 10*int(tt.size); i++ {
+				sf.Add([]byte(fmt.Sprintf("member-%d", i)))
+			}
+			if last := fmt.Sprintf("member-%d", 10*tt.size-1); !sf.Contains([]byte(last)) {
+				t.Errorf("Expected Contains(%s) to be true for the last element added", last)
+			}
+
+			falsePositives := 0
+			const probes = 100000
+			for i := 0; i < probes; i++ {
+				if sf.Contains([]byte(fmt.Sprintf("probe-%d", i))) {
+					falsePositives++
+				}
+			}
+			actualFPR := float64(falsePositives) / probes
+			estimated := sf.FalsePositiveRate()
+			t.Logf("Target FPR: %f, Actual FPR: %f, Estimated FPR: %f", tt.falsePositiveRate, actualFPR, estimated)
+			tolerance := 5 * math.Sqrt(estimated*(1-estimated)/probes)
+			if math.Abs(actualFPR-estimated) > tolerance {
+				t.Errorf("Actual false positive rate %f differs from estimate %f by more than %f", actualFPR, estimated, tolerance)
+			}
+			// The stable point is a mean-field approximation; the fill it
+			// predicts is within a few percent of the real one.
+			if stable := sf.StableFalsePositiveRate(); math.Abs(estimated-stable) > 0.15*stable {
+				t.Errorf("FalsePositiveRate() = %f after %d elements, want close to the stable %f", estimated, 10*tt.size, stable)
+			}
+		})
+	}
+}
+
+func TestStableFilterAddAndTest(t *testing.T) {
+	const size, numHashFuncs, cellBits = 50000, 3, 2
+	decrements, err := OptimalStableDecrements(size, numHashFuncs, cellBits, 0.01)
+	if err != nil {
+		t.Fatalf("OptimalStableDecrements() error = %v", err)
+	}
+	sf, err := NewStableFilter(size, numHashFuncs, cellBits, decrements, nil)
+	if err != nil {
+		t.Fatalf("NewStableFilter() error = %v", err)
+	}
+
+	// Every element is repeated 100 elements after it first appears.
+	const n, gap = 200000, 100
+	firstSeen, repeatSeen := 0, 0
+	for i := 0; i < n; i++ {
+		if sf.AddAndTest([]byte(fmt.Sprintf("event-%d", i))) {
+			firstSeen++
+		}
+		if i >= gap && sf.AddAndTest([]byte(fmt.Sprintf("event-%d", i-gap))) {
+			repeatSeen++
+		}
+	}
+	falsePositiveRate := float64(firstSeen) / n
+	duplicateRate := float64(repeatSeen) / (n - gap)
+	t.Logf("Stable FPR: %f, first occurrences reported as duplicates: %f, repeats detected: %f",
+		sf.StableFalsePositiveRate(), falsePositiveRate, duplicateRate)
+	if falsePositiveRate > 2*sf.StableFalsePositiveRate() {
+		t.Errorf("AddAndTest reported %f of new elements as duplicates, want about %f", falsePositiveRate, sf.StableFalsePositiveRate())
+	}
+	if duplicateRate < 0.99 {
+		t.Errorf("AddAndTest detected %f of recent duplicates, want at least 0.99", duplicateRate)
+	}
+
+	sf.AddAndTest([]byte("twice"))
+	if !sf.AddAndTest([]byte("twice")) {
+		t.Errorf("Expected AddAndTest to report an element added immediately before")
+	}
+}
+
+func TestOptimalStableDecrements(t *testing.T) {
+	tests := []struct {
+		name              string
+		size              uint
+		numHashFuncs      uint
+		cellBits          uint
+		falsePositiveRate float64
+		expected          uint
+		expectedError     error
+	}{
+		{"One-bit cells", 100000, 3, 1, 0.01, 11, nil},
+		{"Two-bit cells", 100000, 4, 2, 0.01, 30, nil},
+		{"High rate needs one decrement", 1000, 10, 1, 0.9, 1, nil},
+		{"Zero size", 0, 3, 1, 0.01, 0, ErrInvalidParameter},
+		{"Zero hashes", 1000, 0, 1, 0.01, 0, ErrInvalidParameter},
+		{"Invalid cell size", 1000, 3, 3, 0.01, 0, ErrInvalidParameter},
+		{"Zero FPR", 1000, 3, 1, 0, 0, ErrInvalidParameter},
+		{"FPR of one", 1000, 3, 1, 1, 0, ErrInvalidParameter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decrements, err := OptimalStableDecrements(tt.size, tt.numHashFuncs, tt.cellBits, tt.falsePositiveRate)
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("OptimalStableDecrements() error = %v, expectedError %v", err, tt.expectedError)
+			}
+			if decrements != tt.expected {
+				t.Errorf("OptimalStableDecrements() = %d, want %d", decrements, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNewStableFilterInvalid(t *testing.T) {
+	tests := []struct {
+		name         string
+		size         uint
+		numHashFuncs uint
+		cellBits     uint
+		decrements   uint
+	}{
+		{"Zero size", 0, 3, 1, 1},
+		{"Zero hashes", 1000, 0, 1, 10},
+		{"More hashes than cells", 10, 11, 1, 1},
+		{"Invalid cell size", 1000, 3, 16, 10},
+		{"Zero decrements", 1000, 3, 1, 0},
+		{"More decrements than cells", 1000, 3, 1, 1001},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sf, err := NewStableFilter(tt.size, tt.numHashFuncs, tt.cellBits, tt.decrements, nil)
+			if sf != nil || !errors.Is(err, ErrInvalidParameter) {
+				t.Errorf("NewStableFilter() error = %v, expectedError %v", err, ErrInvalidParameter)
+			}
+		})
+	}
+}
+
+func TestStableFilterSaveLoad(t *testing.T) {
+	sf, err := NewStableFilter(5000, 3, 2, 20, nil)
+	if err != nil {
+		t.Fatalf("NewStableFilter() error = %v", err)
+	}
+	for i := 0; i < 10000; i++ {
+		sf.Add([]byte(fmt.Sprintf("member-%d", i)))
+	}
+
+	var buf bytes.Buffer
+	if err := sf.Save(&buf); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	var loaded StableFilter
+	if err := loaded.Load(&buf, slog.New(discardHandler{})); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !slices.Equal(loaded.cells, sf.cells) {
+		t.Fatalf("Load() cells differ from the saved filter")
+	}
+
+	// The random number generator state is saved too, so both filters
+	// decrement the same cells from here on.
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("more-%d", i))
+		if sf.AddAndTest(key) != loaded.AddAndTest(key) {
+			t.Fatalf("AddAndTest(%s) differs between the saved and loaded filters", key)
+		}
+	}
+	if !slices.Equal(loaded.cells, sf.cells) {
+		t.Errorf("Cells diverged after adding the same elements to the saved and loaded filters")
+	}
+}
+
+func TestStableFilterLoadOverflowingSize(t *testing.T) {
+	// (size+7)/8 wraps to 0 for the largest size, which would otherwise match
+	// an empty cell array.
+	var buf bytes.Buffer
+	err := writeGobFrame(&buf, kindStable, struct {
+		Cells      []uint64
+		CellBits   uint
+		Size       uint
+		NumHash    uint
+		Decrements uint
+		RNG        uint64
+	}{nil, 8, math.MaxUint, 3, 1, 1})
+	if err != nil {
+		t.Fatalf("writeGobFrame() error = %v", err)
+	}
+	if err := (&StableFilter{}).Load(&buf, nil); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Load() error = %v, expectedError %v", err, ErrInvalidFormat)
+	}
+}
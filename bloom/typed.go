@@ -0,0 +1,119 @@
+package bloom
+
+import (
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Encoder appends the canonical byte encoding of v to dst and returns the
+// extended slice. Filters built by different services agree on their contents
+// only if they encode keys the same way, so the built-in encoders below define
+// one canonical encoding per key type.
+type Encoder[T any] func(dst []byte, v T) ([]byte, error)
+
+// Integer is the set of integer types accepted by EncodeInteger
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// TypedFilter wraps a Filter so that elements of type T are added and queried
+// through an Encoder instead of being converted to []byte by hand.
+//
+//	ids := bloom.NewTypedFilter(bf, bloom.EncodeInteger[int64])
+//	ids.Add(42)
+type TypedFilter[T any] struct {
+	filter *Filter
+	encode Encoder[T]
+}
+
+// NewTypedFilter creates a TypedFilter that stores elements in filter using encode
+func NewTypedFilter[T any](filter *Filter, encode Encoder[T]) *TypedFilter[T] {
+	return &TypedFilter[T]{filter: filter, encode: encode}
+}
+
+// Add encodes v and adds it to the underlying filter. It only fails if the encoder does.
+func (tf *TypedFilter[T]) Add(v T) error {
+	element, err := tf.encode(nil, v)
+	if err != nil {
+		return err
+	}
+	tf.filter.Add(element)
+	return nil
+}
+
+// Contains checks if v might be in the filter. A value the encoder rejects
+// could never have been added, so it is reported as absent.
+func (tf *TypedFilter[T]) Contains(v T) bool {
+	element, err := tf.encode(nil, v)
+	if err != nil {
+		return false
+	}
+	return tf.filter.Contains(element)
+}
+
+// Filter returns the underlying Filter, e.g. to save it
+func (tf *TypedFilter[T]) Filter() *Filter {
+	return tf.filter
+}
+
+// EncodeString encodes a string as its UTF-8 bytes, the same bytes []byte(v) gives
+func EncodeString(dst []byte, v string) ([]byte, error) {
+	return append(dst, v...), nil
+}
+
+// EncodeBytes encodes a byte slice as itself
+func EncodeBytes(dst []byte, v []byte) ([]byte, error) {
+	return append(dst, v...), nil
+}
+
+// EncodeInteger encodes an integer of any width as 8 little-endian bytes.
+// Signed values are sign-extended and unsigned values zero-extended first, so
+// the same number encodes identically whatever type holds it: int32(7) and
+// uint64(7) are the same key. As a consequence a negative number and the
+// unsigned number with the same 64-bit pattern, such as int8(-1) and
+// math.MaxUint64, are also the same key.
+func EncodeInteger[T Integer](dst []byte, v T) ([]byte, error) {
+	return binary.LittleEndian.AppendUint64(dst, uint64(v)), nil
+}
+
+// EncodeUUID encodes a UUID held as 16 bytes in RFC 4122 (network) byte order
+func EncodeUUID(dst []byte, v [16]byte) ([]byte, error) {
+	return append(dst, v[:]...), nil
+}
+
+// EncodeUUIDString parses a UUID in its 36-character text form, in either
+// case, and encodes it like EncodeUUID, so text and binary UUIDs are the same key
+func EncodeUUIDString(dst []byte, v string) ([]byte, error) {
+	if len(v) != 36 || v[8] != '-' || v[13] != '-' || v[18] != '-' || v[23] != '-' {
+		return nil, fmt.Errorf("bloom: invalid UUID %q", v)
+	}
+	var uuid [16]byte
+	if _, err := hex.Decode(uuid[:], []byte(strings.ReplaceAll(v, "-", ""))); err != nil {
+		return nil, fmt.Errorf("bloom: invalid UUID %q: %w", v, err)
+	}
+	return append(dst, uuid[:]...), nil
+}
+
+// EncodeAddr encodes an IP address as 4 bytes for IPv4 or 16 bytes for IPv6,
+// followed by the zone if any. IPv4-mapped IPv6 addresses are unmapped first,
+// so ::ffff:192.0.2.1 and 192.0.2.1 are the same key. The zero Addr is rejected.
+func EncodeAddr(dst []byte, v netip.Addr) ([]byte, error) {
+	if !v.IsValid() {
+		return nil, fmt.Errorf("bloom: invalid IP address")
+	}
+	dst = append(dst, v.Unmap().AsSlice()...)
+	return append(dst, v.Zone()...), nil
+}
+
+// EncodeBinaryMarshaler encodes any value implementing encoding.BinaryMarshaler with its MarshalBinary method
+func EncodeBinaryMarshaler[T encoding.BinaryMarshaler](dst []byte, v T) ([]byte, error) {
+	b, err := v.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, b...), nil
+}
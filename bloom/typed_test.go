@@ -0,0 +1,153 @@
+package bloom
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/netip"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestFilter() *Filter {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	return NewBloomFilter(10000, 5, logger)
+}
+
+func TestTypedFilterIntegerWidthsAgree(t *testing.T) {
+	bf := newTestFilter()
+	if err := NewTypedFilter(bf, EncodeInteger[int64]).Add(42); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := NewTypedFilter(bf, EncodeInteger[int8]).Add(-3); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	checks := []struct {
+		name     string
+		contains bool
+	}{
+		{"int", NewTypedFilter(bf, EncodeInteger[int]).Contains(42)},
+		{"int16", NewTypedFilter(bf, EncodeInteger[int16]).Contains(42)},
+		{"int32", NewTypedFilter(bf, EncodeInteger[int32]).Contains(42)},
+		{"uint8", NewTypedFilter(bf, EncodeInteger[uint8]).Contains(42)},
+		{"uint32", NewTypedFilter(bf, EncodeInteger[uint32]).Contains(42)},
+		{"uint64", NewTypedFilter(bf, EncodeInteger[uint64]).Contains(42)},
+		{"negative int64", NewTypedFilter(bf, EncodeInteger[int64]).Contains(-3)},
+		{"negative int", NewTypedFilter(bf, EncodeInteger[int]).Contains(-3)},
+	}
+	for _, c := range checks {
+		if !c.contains {
+			t.Errorf("Expected %s encoding to find the key", c.name)
+		}
+	}
+
+	// The canonical encoding is the little-endian 64-bit pattern, not text.
+	if NewTypedFilter(bf, EncodeString).Contains(strconv.Itoa(42)) {
+		t.Errorf("Expected the decimal string to be a different key")
+	}
+	if !NewTypedFilter(bf, EncodeInteger[uint64]).Contains(math.MaxUint64 - 2) {
+		t.Errorf("Expected uint64 with the same bit pattern as -3 to be the same key")
+	}
+}
+
+func TestTypedFilterStringAndBytesAgree(t *testing.T) {
+	bf := newTestFilter()
+	if err := NewTypedFilter(bf, EncodeString).Add("hello"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if !NewTypedFilter(bf, EncodeBytes).Contains([]byte("hello")) || !bf.Contains([]byte("hello")) {
+		t.Errorf("Expected string, []byte and raw Filter keys to agree")
+	}
+}
+
+func TestTypedFilterUUID(t *testing.T) {
+	bf := newTestFilter()
+	uuid := [16]byte{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}
+	if err := NewTypedFilter(bf, EncodeUUID).Add(uuid); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	text := NewTypedFilter(bf, EncodeUUIDString)
+	if !text.Contains("123e4567-e89b-12d3-a456-426614174000") || !text.Contains("123E4567-E89B-12D3-A456-426614174000") {
+		t.Errorf("Expected text UUIDs in either case to match the binary UUID")
+	}
+	for _, invalid := range []string{"", "123e4567e89b12d3a456426614174000", "123e4567-e89b-12d3-a456-42661417400g"} {
+		if err := text.Add(invalid); err == nil {
+			t.Errorf("Expected Add(%q) to fail", invalid)
+		}
+		if text.Contains(invalid) {
+			t.Errorf("Expected Contains(%q) to be false", invalid)
+		}
+	}
+}
+
+func TestTypedFilterAddr(t *testing.T) {
+	bf := newTestFilter()
+	addrs := NewTypedFilter(bf, EncodeAddr)
+	for _, s := range []string{"192.0.2.1", "2001:db8::1", "fe80::1%eth0"} {
+		if err := addrs.Add(netip.MustParseAddr(s)); err != nil {
+			t.Fatalf("Add(%s) error = %v", s, err)
+		}
+	}
+
+	tests := []struct {
+		addr     string
+		expected bool
+	}{
+		{"192.0.2.1", true},
+		{"::ffff:192.0.2.1", true},
+		{"2001:db8:0:0:0:0:0:1", true},
+		{"fe80::1%eth0", true},
+		{"fe80::1", false},
+		{"192.0.2.2", false},
+	}
+	for _, tt := range tests {
+		if got := addrs.Contains(netip.MustParseAddr(tt.addr)); got != tt.expected {
+			t.Errorf("Contains(%s) = %v, want %v", tt.addr, got, tt.expected)
+		}
+	}
+	if err := addrs.Add(netip.Addr{}); err == nil {
+		t.Errorf("Expected Add of the zero Addr to fail")
+	}
+}
+
+func TestTypedFilterBinaryMarshaler(t *testing.T) {
+	bf := newTestFilter()
+	times := NewTypedFilter(bf, EncodeBinaryMarshaler[time.Time])
+	instant := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := times.Add(instant); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if !times.Contains(instant) {
+		t.Errorf("Expected filter to contain %v", instant)
+	}
+	if times.Contains(instant.Add(time.Second)) {
+		t.Errorf("Expected filter not to contain %v", instant.Add(time.Second))
+	}
+}
+
+func TestTypedFilterCustomEncoder(t *testing.T) {
+	type point struct{ X, Y int32 }
+	errNegative := errors.New("negative coordinate")
+	encodePoint := func(dst []byte, p point) ([]byte, error) {
+		if p.X < 0 || p.Y < 0 {
+			return nil, errNegative
+		}
+		dst, _ = EncodeInteger(dst, p.X)
+		return EncodeInteger(dst, p.Y)
+	}
+
+	points := NewTypedFilter(newTestFilter(), encodePoint)
+	if err := points.Add(point{1, 2}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if !points.Contains(point{1, 2}) || points.Contains(point{2, 1}) {
+		t.Errorf("Custom encoder did not distinguish points")
+	}
+	if err := points.Add(point{-1, 0}); !errors.Is(err, errNegative) {
+		t.Errorf("Add() error = %v, expectedError %v", err, errNegative)
+	}
+}
@@ -0,0 +1,256 @@
+package bloom
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// SlidingWindowFilter is an age-partitioned Bloom filter that answers whether
+// an element was added within a recent time window rather than ever.
+//
+// Time is divided into generations of one granularity each. Elements are added
+// to the Filter of the current generation, and Contains checks every
+// generation that overlaps the window. When the clock passes the end of the
+// current generation, the oldest Filter is cleared and reused for the next
+// one, so memory stays at ceil(window/granularity)+1 Filters however long the
+// filter runs.
+//
+// Expiry is exact to one granularity: an element added at time t is reported
+// until at least t+window and at most t+window+granularity. Generations
+// rotate lazily when the filter is used, so every method, Contains included,
+// may modify the filter, and it is not safe for concurrent use.
+type SlidingWindowFilter struct {
+	generations []windowGeneration
+	current     int
+	window      time.Duration
+	granularity time.Duration
+	now         func() time.Time
+	logger      *slog.Logger
+}
+
+// windowGeneration holds the elements added during [start, start+granularity)
+type windowGeneration struct {
+	filter *Filter
+	start  time.Time
+}
+
+// NewSlidingWindowFilter creates a new sliding-window Bloom filter that
+// remembers elements for window, with generations of granularity, each a
+// Filter with the given size and number of hash functions. Size the Filters
+// with OptimalSize for the number of elements expected per granularity; a
+// query tests all ceil(window/granularity)+1 of them, so its false positive
+// rate is up to that many times theirs.
+//
+// The window and granularity must be positive and the granularity at most the
+// window. now supplies the current time; a nil now uses time.Now. A nil logger
+// discards log output.
+func NewSlidingWindowFilter(window, granularity time.Duration, size, numHashFuncs uint, now func() time.Time, logger *slog.Logger) (*SlidingWindowFilter, error) {
+	if window <= 0 {
+		return nil, fmt.Errorf("%w: window must be positive, got %v", ErrInvalidParameter, window)
+	}
+	if granularity <= 0 || granularity > window {
+		return nil, fmt.Errorf("%w: granularity must be positive and at most the window %v, got %v", ErrInvalidParameter, window, granularity)
+	}
+	if size == 0 || numHashFuncs == 0 {
+		return nil, fmt.Errorf("%w: size and hash function count must be positive, got %d and %d", ErrInvalidParameter, size, numHashFuncs)
+	}
+	if now == nil {
+		now = time.Now
+	}
+	logger = orDiscard(logger)
+
+	wf := &SlidingWindowFilter{
+		generations: make([]windowGeneration, windowGenerations(window, granularity)),
+		window:      window,
+		granularity: granularity,
+		now:         now,
+		logger:      logger,
+	}
+	for i := range wf.generations {
+		wf.generations[i].filter = NewBloomFilter(size, numHashFuncs, logger)
+	}
+	wf.generations[0].start = now()
+
+	wf.logger.Info("Created new sliding window Bloom filter", "window", window, "granularity", granularity,
+		"generations", len(wf.generations), "size", size, "numHashFuncs", numHashFuncs)
+	return wf, nil
+}
+
+// windowGenerations returns the number of generations that can overlap a window
+func windowGenerations(window, granularity time.Duration) int {
+	return int((window+granularity-1)/granularity) + 1
+}
+
+// Add adds an element to the current generation
+func (wf *SlidingWindowFilter) Add(element []byte) {
+	wf.rotate()
+	g := wf.generations[wf.current].filter
+	h1, h2 := g.hasher.Sum128(element)
+	g.addHash(h1, h2)
+	wf.logger.Info("Added element to sliding window Bloom filter", "element", string(element))
+}
+
+// Contains checks if an element might have been added within the window
+func (wf *SlidingWindowFilter) Contains(element []byte) bool {
+	now := wf.rotate()
+	h1, h2 := wf.generations[wf.current].filter.hasher.Sum128(element)
+	for _, g := range wf.generations {
+		if wf.live(g, now) && g.filter.containsHash(h1, h2) {
+			wf.logger.Info("Element possibly in sliding window Bloom filter", "element", string(element), "generation", g.start)
+			return true
+		}
+	}
+	wf.logger.Debug("Element not found in sliding window Bloom filter", "element", string(element))
+	return false
+}
+
+// FalsePositiveRate calculates the current false positive rate of the sliding
+// window filter, the probability that any live generation reports a false
+// positive
+func (wf *SlidingWindowFilter) FalsePositiveRate() float64 {
+	now := wf.rotate()
+	negative := 1.0
+	for _, g := range wf.generations {
+		if wf.live(g, now) {
+			negative *= 1 - g.filter.FalsePositiveRate()
+		}
+	}
+	return 1 - negative
+}
+
+// Window returns how long the filter remembers an element
+func (wf *SlidingWindowFilter) Window() time.Duration {
+	return wf.window
+}
+
+// Granularity returns the time span of one generation
+func (wf *SlidingWindowFilter) Granularity() time.Duration {
+	return wf.granularity
+}
+
+// Save serializes the sliding window filter to a writer, including the start
+// time of every generation
+func (wf *SlidingWindowFilter) Save(w io.Writer) error {
+	starts := make([]time.Time, len(wf.generations))
+	filters := make([][]byte, len(wf.generations))
+	for i, g := range wf.generations {
+		var buf bytes.Buffer
+		if err := g.filter.Save(&buf); err != nil {
+			return err
+		}
+		starts[i] = g.start
+		filters[i] = buf.Bytes()
+	}
+
+	return writeGobFrame(w, kindSlidingWindow, struct {
+		Window      time.Duration
+		Granularity time.Duration
+		Current     int
+		Starts      []time.Time
+		Filters     [][]byte
+	}{
+		Window:      wf.window,
+		Granularity: wf.granularity,
+		Current:     wf.current,
+		Starts:      starts,
+		Filters:     filters,
+	})
+}
+
+// Load deserializes the sliding window filter from a reader. Generations keep
+// their saved start times, so elements expire as if the filter had never been
+// saved. A filter created with NewSlidingWindowFilter keeps its clock; a zero
+// SlidingWindowFilter uses time.Now.
+func (wf *SlidingWindowFilter) Load(r io.Reader, logger *slog.Logger) error {
+	var data struct {
+		Window      time.Duration
+		Granularity time.Duration
+		Current     int
+		Starts      []time.Time
+		Filters     [][]byte
+	}
+	if err := readGobFrame(r, kindSlidingWindow, &data); err != nil {
+		return err
+	}
+	if data.Window <= 0 || data.Granularity <= 0 || data.Granularity > data.Window {
+		return fmt.Errorf("%w: sliding window of %v with granularity %v", ErrInvalidFormat, data.Window, data.Granularity)
+	}
+	n := windowGenerations(data.Window, data.Granularity)
+	if len(data.Filters) != n || len(data.Starts) != n || data.Current < 0 || data.Current >= n {
+		return fmt.Errorf("%w: sliding window filter has %d generations and %d start times, want %d", ErrInvalidFormat, len(data.Filters), len(data.Starts), n)
+	}
+
+	generations := make([]windowGeneration, n)
+	for i, encoded := range data.Filters {
+		generations[i] = windowGeneration{filter: &Filter{}, start: data.Starts[i]}
+		if err := generations[i].filter.Load(bytes.NewReader(encoded), logger); err != nil {
+			return fmt.Errorf("bloom: loading generation %d: %w", i, err)
+		}
+		if err := generations[i].filter.Compatible(generations[0].filter); err != nil {
+			return fmt.Errorf("%w: generation %d: %v", ErrInvalidFormat, i, err)
+		}
+	}
+
+	wf.generations = generations
+	wf.current = data.Current
+	wf.window = data.Window
+	wf.granularity = data.Granularity
+	if wf.now == nil {
+		wf.now = time.Now
+	}
+	wf.logger = orDiscard(logger)
+	return nil
+}
+
+// rotate starts a new generation for every granularity that has passed since
+// the current one started, clearing the oldest ones, and returns the current
+// time. A clock that goes backwards never rotates.
+func (wf *SlidingWindowFilter) rotate() time.Time {
+	now := wf.now()
+	start := wf.generations[wf.current].start
+	elapsed := now.Sub(start)
+	if elapsed < wf.granularity {
+		return now
+	}
+
+	// After a long pause every generation has expired; only the newest
+	// len(generations) of the skipped ones need a Filter.
+	steps := int64(elapsed / wf.granularity)
+	fresh := min(steps, int64(len(wf.generations)))
+	latest := start.Add(time.Duration(steps) * wf.granularity)
+	for i := fresh - 1; i >= 0; i-- {
+		wf.current = (wf.current + 1) % len(wf.generations)
+		g := &wf.generations[wf.current]
+		clear(g.filter.bits)
+		g.start = latest.Add(-time.Duration(i) * wf.granularity)
+	}
+	wf.logger.Debug("Rotated sliding window Bloom filter", "generations", fresh, "start", latest)
+	return now
+}
+
+// live reports whether a generation overlaps the window ending at now
+func (wf *SlidingWindowFilter) live(g windowGeneration, now time.Time) bool {
+	return !g.start.IsZero() && g.start.Add(wf.granularity).After(now.Add(-wf.window))
+}
+
+// addHash sets the bits of an element with digest h1, h2
+func (bf *Filter) addHash(h1, h2 uint64) {
+	loc := locatorOf(bf.hasher)
+	for i := uint(0); i < bf.numHashFuncs; i++ {
+		bf.bits.set(locate(loc, h1, h2, i, bf.size))
+	}
+}
+
+// containsHash tests the bits of an element with digest h1, h2
+func (bf *Filter) containsHash(h1, h2 uint64) bool {
+	loc := locatorOf(bf.hasher)
+	for i := uint(0); i < bf.numHashFuncs; i++ {
+		if !bf.bits.test(locate(loc, h1, h2, i, bf.size)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,194 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for sliding window tests
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.t }
+
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func TestSlidingWindowFilterExpiry(t *testing.T) {
+	tests := []struct {
+		name     string
+		elapsed  time.Duration
+		expected bool
+	}{
+		{"Immediately", 0, true},
+		{"Within the first generation", 59 * time.Second, true},
+		{"Halfway through the window", 5 * time.Minute, true},
+		{"Just before the window ends", 10*time.Minute - time.Second, true},
+		{"Within one granularity past the window", 10*time.Minute + 30*time.Second, true},
+		{"One granularity past the window", 11 * time.Minute, false},
+		{"Long after the window", 3 * time.Hour, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			wf, err := NewSlidingWindowFilter(10*time.Minute, time.Minute, 1000, 5, clock.Now, nil)
+			if err != nil {
+				t.Fatalf("NewSlidingWindowFilter() error = %v", err)
+			}
+			wf.Add([]byte("hello"))
+			clock.Advance(tt.elapsed)
+			if got := wf.Contains([]byte("hello")); got != tt.expected {
+				t.Errorf("Contains() after %v = %v, want %v", tt.elapsed, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSlidingWindowFilterRotation(t *testing.T) {
+	clock := newFakeClock()
+	// A generous size keeps false positives out of the expiry checks below.
+	wf, err := NewSlidingWindowFilter(time.Minute, 10*time.Second, 100003, 5, clock.Now, nil)
+	if err != nil {
+		t.Fatalf("NewSlidingWindowFilter() error = %v", err)
+	}
+	if len(wf.generations) != 7 {
+		t.Errorf("Expected 7 generations for a 1m window at 10s granularity, got %d", len(wf.generations))
+	}
+
+	// An element added every second stays visible for the whole window while
+	// the generations rotate many times over.
+	for i := 0; i < 600; i++ {
+		wf.Add([]byte(fmt.Sprintf("tick-%d", i)))
+		for j := max(0, i-59); j <= i; j++ {
+			if !wf.Contains([]byte(fmt.Sprintf("tick-%d", j))) {
+				t.Fatalf("At tick %d: expected Contains(tick-%d) to be true", i, j)
+			}
+		}
+		clock.Advance(time.Second)
+	}
+	for j := 0; j < 600-70; j++ {
+		if wf.Contains([]byte(fmt.Sprintf("tick-%d", j))) {
+			t.Errorf("Expected tick-%d to have expired", j)
+		}
+	}
+
+	// A clock that goes backwards does not rotate or lose anything.
+	clock.Advance(-30 * time.Second)
+	if !wf.Contains([]byte("tick-599")) {
+		t.Errorf("Expected Contains(tick-599) to be true after the clock went backwards")
+	}
+}
+
+func TestSlidingWindowFilterFalsePositiveRate(t *testing.T) {
+	clock := newFakeClock()
+	const perGeneration = 1000
+	size := OptimalSize(perGeneration, 0.001)
+	wf, err := NewSlidingWindowFilter(10*time.Minute, time.Minute, size, OptimalHashFunctions(size, perGeneration), clock.Now, nil)
+	if err != nil {
+		t.Fatalf("NewSlidingWindowFilter() error = %v", err)
+	}
+	// An hour of elements, far more than one Filter of this size could hold.
+	for minute := 0; minute < 60; minute++ {
+		for i := 0; i < perGeneration; i++ {
+			wf.Add([]byte(fmt.Sprintf("member-%d-%d", minute, i)))
+		}
+		clock.Advance(time.Minute)
+	}
+
+	falsePositives := 0
+	const probes = 100000
+	for i := 0; i < probes; i++ {
+		if wf.Contains([]byte(fmt.Sprintf("probe-%d", i))) {
+			falsePositives++
+		}
+	}
+	actualFPR := float64(falsePositives) / probes
+	estimated := wf.FalsePositiveRate()
+	t.Logf("Actual FPR: %f, Estimated FPR: %f", actualFPR, estimated)
+	tolerance := 5 * math.Sqrt(estimated*(1-estimated)/probes)
+	if math.Abs(actualFPR-estimated) > tolerance {
+		t.Errorf("Actual false positive rate %f differs from estimate %f by more than %f", actualFPR, estimated, tolerance)
+	}
+	if estimated > 0.011 {
+		t.Errorf("FalsePositiveRate() = %f, want at most about ten generations of 0.001", estimated)
+	}
+}
+
+func TestSlidingWindowFilterSaveLoad(t *testing.T) {
+	clock := newFakeClock()
+	wf, err := NewSlidingWindowFilter(10*time.Minute, time.Minute, 1000, 5, clock.Now, nil)
+	if err != nil {
+		t.Fatalf("NewSlidingWindowFilter() error = %v", err)
+	}
+	wf.Add([]byte("early"))
+	clock.Advance(5 * time.Minute)
+	wf.Add([]byte("late"))
+
+	var buf bytes.Buffer
+	if err := wf.Save(&buf); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// The loaded filter runs on its own clock, which has moved on since.
+	loadClock := newFakeClock()
+	loadClock.Advance(10*time.Minute + 30*time.Second)
+	loaded, err := NewSlidingWindowFilter(time.Hour, time.Hour, 1, 1, loadClock.Now, nil)
+	if err != nil {
+		t.Fatalf("NewSlidingWindowFilter() error = %v", err)
+	}
+	if err := loaded.Load(&buf, slog.New(discardHandler{})); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.Window() != 10*time.Minute || loaded.Granularity() != time.Minute {
+		t.Errorf("Load() gave window %v and granularity %v, want 10m0s and 1m0s", loaded.Window(), loaded.Granularity())
+	}
+	if !loaded.Contains([]byte("early")) || !loaded.Contains([]byte("late")) {
+		t.Errorf("Expected loaded filter to contain early and late")
+	}
+	loadClock.Advance(30 * time.Second)
+	if loaded.Contains([]byte("early")) {
+		t.Errorf("Expected early to expire at its saved time")
+	}
+	if !loaded.Contains([]byte("late")) {
+		t.Errorf("Expected Contains(late) to be true")
+	}
+	loadClock.Advance(5 * time.Minute)
+	if loaded.Contains([]byte("late")) {
+		t.Errorf("Expected late to expire at its saved time")
+	}
+}
+
+func TestNewSlidingWindowFilterInvalid(t *testing.T) {
+	tests := []struct {
+		name         string
+		window       time.Duration
+		granularity  time.Duration
+		size         uint
+		numHashFuncs uint
+	}{
+		{"Zero window", 0, time.Second, 100, 3},
+		{"Zero granularity", time.Minute, 0, 100, 3},
+		{"Granularity longer than window", time.Minute, time.Hour, 100, 3},
+		{"Zero size", time.Minute, time.Second, 0, 3},
+		{"Zero hashes", time.Minute, time.Second, 100, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wf, err := NewSlidingWindowFilter(tt.window, tt.granularity, tt.size, tt.numHashFuncs, nil, nil)
+			if wf != nil || !errors.Is(err, ErrInvalidParameter) {
+				t.Errorf("NewSlidingWindowFilter() error = %v, expectedError %v", err, ErrInvalidParameter)
+			}
+		})
+	}
+}